@@ -1,7 +1,7 @@
 @echo off
 if exist go_aes.8 del go_aes.8
 if exist go_aes.exe del go_aes.exe
-8g go_aes.go
+8g go_aes.go shared_aes.go shared_des.go shared_rsa.go shared_tr31.go shared_util.go
 if exist go_aes.8 8l -o go_aes.exe go_aes.8
 if exist go_aes.exe go_aes.exe
 pause
\ No newline at end of file
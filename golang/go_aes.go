@@ -1,5 +1,7 @@
-// Simple, thoroughly commented implementation of 128-bit AES / Rijndael using Google Go aka Golang
+// Simple, thoroughly commented implementation of AES / Rijndael using Google Go aka Golang
 // Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog
+// The cipher itself is in shared_aes.go, which go_rsa.go uses too, so build this along with the shared files:
+//  go run go_aes.go shared_*.go
 // References:
 // http://en.wikipedia.org/wiki/Advanced_Encryption_Standard
 // http://en.wikipedia.org/wiki/Rijndael_key_schedule
@@ -7,234 +9,802 @@
 // http://en.wikipedia.org/wiki/Rijndael_S-box
 
 package main
-import "fmt"
-
-// Here are all the lookup tables for the row shifts, rcon, s-boxes, and galois field multiplications
-var shift_rows_table     = [...]byte{0,5,10,15,4,9,14,3,8,13,2,7,12,1,6,11}
-var shift_rows_table_inv = [...]byte{0,13,10,7,4,1,14,11,8,5,2,15,12,9,6,3}
-var lookup_rcon     = [...]byte{0x8d,0x01,0x02,0x04,0x08,0x10,0x20,0x40,0x80,0x1b,0x36,0x6c,0xd8,0xab,0x4d,0x9a}
-var lookup_sbox     = [...]byte{0x63,0x7c,0x77,0x7b,0xf2,0x6b,0x6f,0xc5,0x30,0x01,0x67,0x2b,0xfe,0xd7,0xab,0x76,0xca,0x82,0xc9,0x7d,0xfa,0x59,0x47,0xf0,0xad,0xd4,0xa2,0xaf,0x9c,0xa4,0x72,0xc0,0xb7,0xfd,0x93,0x26,0x36,0x3f,0xf7,0xcc,0x34,0xa5,0xe5,0xf1,0x71,0xd8,0x31,0x15,0x04,0xc7,0x23,0xc3,0x18,0x96,0x05,0x9a,0x07,0x12,0x80,0xe2,0xeb,0x27,0xb2,0x75,0x09,0x83,0x2c,0x1a,0x1b,0x6e,0x5a,0xa0,0x52,0x3b,0xd6,0xb3,0x29,0xe3,0x2f,0x84,0x53,0xd1,0x00,0xed,0x20,0xfc,0xb1,0x5b,0x6a,0xcb,0xbe,0x39,0x4a,0x4c,0x58,0xcf,0xd0,0xef,0xaa,0xfb,0x43,0x4d,0x33,0x85,0x45,0xf9,0x02,0x7f,0x50,0x3c,0x9f,0xa8,0x51,0xa3,0x40,0x8f,0x92,0x9d,0x38,0xf5,0xbc,0xb6,0xda,0x21,0x10,0xff,0xf3,0xd2,0xcd,0x0c,0x13,0xec,0x5f,0x97,0x44,0x17,0xc4,0xa7,0x7e,0x3d,0x64,0x5d,0x19,0x73,0x60,0x81,0x4f,0xdc,0x22,0x2a,0x90,0x88,0x46,0xee,0xb8,0x14,0xde,0x5e,0x0b,0xdb,0xe0,0x32,0x3a,0x0a,0x49,0x06,0x24,0x5c,0xc2,0xd3,0xac,0x62,0x91,0x95,0xe4,0x79,0xe7,0xc8,0x37,0x6d,0x8d,0xd5,0x4e,0xa9,0x6c,0x56,0xf4,0xea,0x65,0x7a,0xae,0x08,0xba,0x78,0x25,0x2e,0x1c,0xa6,0xb4,0xc6,0xe8,0xdd,0x74,0x1f,0x4b,0xbd,0x8b,0x8a,0x70,0x3e,0xb5,0x66,0x48,0x03,0xf6,0x0e,0x61,0x35,0x57,0xb9,0x86,0xc1,0x1d,0x9e,0xe1,0xf8,0x98,0x11,0x69,0xd9,0x8e,0x94,0x9b,0x1e,0x87,0xe9,0xce,0x55,0x28,0xdf,0x8c,0xa1,0x89,0x0d,0xbf,0xe6,0x42,0x68,0x41,0x99,0x2d,0x0f,0xb0,0x54,0xbb,0x16}
-var lookup_sbox_inv = [...]byte{0x52,0x09,0x6a,0xd5,0x30,0x36,0xa5,0x38,0xbf,0x40,0xa3,0x9e,0x81,0xf3,0xd7,0xfb,0x7c,0xe3,0x39,0x82,0x9b,0x2f,0xff,0x87,0x34,0x8e,0x43,0x44,0xc4,0xde,0xe9,0xcb,0x54,0x7b,0x94,0x32,0xa6,0xc2,0x23,0x3d,0xee,0x4c,0x95,0x0b,0x42,0xfa,0xc3,0x4e,0x08,0x2e,0xa1,0x66,0x28,0xd9,0x24,0xb2,0x76,0x5b,0xa2,0x49,0x6d,0x8b,0xd1,0x25,0x72,0xf8,0xf6,0x64,0x86,0x68,0x98,0x16,0xd4,0xa4,0x5c,0xcc,0x5d,0x65,0xb6,0x92,0x6c,0x70,0x48,0x50,0xfd,0xed,0xb9,0xda,0x5e,0x15,0x46,0x57,0xa7,0x8d,0x9d,0x84,0x90,0xd8,0xab,0x00,0x8c,0xbc,0xd3,0x0a,0xf7,0xe4,0x58,0x05,0xb8,0xb3,0x45,0x06,0xd0,0x2c,0x1e,0x8f,0xca,0x3f,0x0f,0x02,0xc1,0xaf,0xbd,0x03,0x01,0x13,0x8a,0x6b,0x3a,0x91,0x11,0x41,0x4f,0x67,0xdc,0xea,0x97,0xf2,0xcf,0xce,0xf0,0xb4,0xe6,0x73,0x96,0xac,0x74,0x22,0xe7,0xad,0x35,0x85,0xe2,0xf9,0x37,0xe8,0x1c,0x75,0xdf,0x6e,0x47,0xf1,0x1a,0x71,0x1d,0x29,0xc5,0x89,0x6f,0xb7,0x62,0x0e,0xaa,0x18,0xbe,0x1b,0xfc,0x56,0x3e,0x4b,0xc6,0xd2,0x79,0x20,0x9a,0xdb,0xc0,0xfe,0x78,0xcd,0x5a,0xf4,0x1f,0xdd,0xa8,0x33,0x88,0x07,0xc7,0x31,0xb1,0x12,0x10,0x59,0x27,0x80,0xec,0x5f,0x60,0x51,0x7f,0xa9,0x19,0xb5,0x4a,0x0d,0x2d,0xe5,0x7a,0x9f,0x93,0xc9,0x9c,0xef,0xa0,0xe0,0x3b,0x4d,0xae,0x2a,0xf5,0xb0,0xc8,0xeb,0xbb,0x3c,0x83,0x53,0x99,0x61,0x17,0x2b,0x04,0x7e,0xba,0x77,0xd6,0x26,0xe1,0x69,0x14,0x63,0x55,0x21,0x0c,0x7d}
-var lookup_g2       = [...]byte{0x00,0x02,0x04,0x06,0x08,0x0a,0x0c,0x0e,0x10,0x12,0x14,0x16,0x18,0x1a,0x1c,0x1e,0x20,0x22,0x24,0x26,0x28,0x2a,0x2c,0x2e,0x30,0x32,0x34,0x36,0x38,0x3a,0x3c,0x3e,0x40,0x42,0x44,0x46,0x48,0x4a,0x4c,0x4e,0x50,0x52,0x54,0x56,0x58,0x5a,0x5c,0x5e,0x60,0x62,0x64,0x66,0x68,0x6a,0x6c,0x6e,0x70,0x72,0x74,0x76,0x78,0x7a,0x7c,0x7e,0x80,0x82,0x84,0x86,0x88,0x8a,0x8c,0x8e,0x90,0x92,0x94,0x96,0x98,0x9a,0x9c,0x9e,0xa0,0xa2,0xa4,0xa6,0xa8,0xaa,0xac,0xae,0xb0,0xb2,0xb4,0xb6,0xb8,0xba,0xbc,0xbe,0xc0,0xc2,0xc4,0xc6,0xc8,0xca,0xcc,0xce,0xd0,0xd2,0xd4,0xd6,0xd8,0xda,0xdc,0xde,0xe0,0xe2,0xe4,0xe6,0xe8,0xea,0xec,0xee,0xf0,0xf2,0xf4,0xf6,0xf8,0xfa,0xfc,0xfe,0x1b,0x19,0x1f,0x1d,0x13,0x11,0x17,0x15,0x0b,0x09,0x0f,0x0d,0x03,0x01,0x07,0x05,0x3b,0x39,0x3f,0x3d,0x33,0x31,0x37,0x35,0x2b,0x29,0x2f,0x2d,0x23,0x21,0x27,0x25,0x5b,0x59,0x5f,0x5d,0x53,0x51,0x57,0x55,0x4b,0x49,0x4f,0x4d,0x43,0x41,0x47,0x45,0x7b,0x79,0x7f,0x7d,0x73,0x71,0x77,0x75,0x6b,0x69,0x6f,0x6d,0x63,0x61,0x67,0x65,0x9b,0x99,0x9f,0x9d,0x93,0x91,0x97,0x95,0x8b,0x89,0x8f,0x8d,0x83,0x81,0x87,0x85,0xbb,0xb9,0xbf,0xbd,0xb3,0xb1,0xb7,0xb5,0xab,0xa9,0xaf,0xad,0xa3,0xa1,0xa7,0xa5,0xdb,0xd9,0xdf,0xdd,0xd3,0xd1,0xd7,0xd5,0xcb,0xc9,0xcf,0xcd,0xc3,0xc1,0xc7,0xc5,0xfb,0xf9,0xff,0xfd,0xf3,0xf1,0xf7,0xf5,0xeb,0xe9,0xef,0xed,0xe3,0xe1,0xe7,0xe5}
-var lookup_g3       = [...]byte{0x00,0x03,0x06,0x05,0x0c,0x0f,0x0a,0x09,0x18,0x1b,0x1e,0x1d,0x14,0x17,0x12,0x11,0x30,0x33,0x36,0x35,0x3c,0x3f,0x3a,0x39,0x28,0x2b,0x2e,0x2d,0x24,0x27,0x22,0x21,0x60,0x63,0x66,0x65,0x6c,0x6f,0x6a,0x69,0x78,0x7b,0x7e,0x7d,0x74,0x77,0x72,0x71,0x50,0x53,0x56,0x55,0x5c,0x5f,0x5a,0x59,0x48,0x4b,0x4e,0x4d,0x44,0x47,0x42,0x41,0xc0,0xc3,0xc6,0xc5,0xcc,0xcf,0xca,0xc9,0xd8,0xdb,0xde,0xdd,0xd4,0xd7,0xd2,0xd1,0xf0,0xf3,0xf6,0xf5,0xfc,0xff,0xfa,0xf9,0xe8,0xeb,0xee,0xed,0xe4,0xe7,0xe2,0xe1,0xa0,0xa3,0xa6,0xa5,0xac,0xaf,0xaa,0xa9,0xb8,0xbb,0xbe,0xbd,0xb4,0xb7,0xb2,0xb1,0x90,0x93,0x96,0x95,0x9c,0x9f,0x9a,0x99,0x88,0x8b,0x8e,0x8d,0x84,0x87,0x82,0x81,0x9b,0x98,0x9d,0x9e,0x97,0x94,0x91,0x92,0x83,0x80,0x85,0x86,0x8f,0x8c,0x89,0x8a,0xab,0xa8,0xad,0xae,0xa7,0xa4,0xa1,0xa2,0xb3,0xb0,0xb5,0xb6,0xbf,0xbc,0xb9,0xba,0xfb,0xf8,0xfd,0xfe,0xf7,0xf4,0xf1,0xf2,0xe3,0xe0,0xe5,0xe6,0xef,0xec,0xe9,0xea,0xcb,0xc8,0xcd,0xce,0xc7,0xc4,0xc1,0xc2,0xd3,0xd0,0xd5,0xd6,0xdf,0xdc,0xd9,0xda,0x5b,0x58,0x5d,0x5e,0x57,0x54,0x51,0x52,0x43,0x40,0x45,0x46,0x4f,0x4c,0x49,0x4a,0x6b,0x68,0x6d,0x6e,0x67,0x64,0x61,0x62,0x73,0x70,0x75,0x76,0x7f,0x7c,0x79,0x7a,0x3b,0x38,0x3d,0x3e,0x37,0x34,0x31,0x32,0x23,0x20,0x25,0x26,0x2f,0x2c,0x29,0x2a,0x0b,0x08,0x0d,0x0e,0x07,0x04,0x01,0x02,0x13,0x10,0x15,0x16,0x1f,0x1c,0x19,0x1a}
-var lookup_g9       = [...]byte{0x00,0x09,0x12,0x1b,0x24,0x2d,0x36,0x3f,0x48,0x41,0x5a,0x53,0x6c,0x65,0x7e,0x77,0x90,0x99,0x82,0x8b,0xb4,0xbd,0xa6,0xaf,0xd8,0xd1,0xca,0xc3,0xfc,0xf5,0xee,0xe7,0x3b,0x32,0x29,0x20,0x1f,0x16,0x0d,0x04,0x73,0x7a,0x61,0x68,0x57,0x5e,0x45,0x4c,0xab,0xa2,0xb9,0xb0,0x8f,0x86,0x9d,0x94,0xe3,0xea,0xf1,0xf8,0xc7,0xce,0xd5,0xdc,0x76,0x7f,0x64,0x6d,0x52,0x5b,0x40,0x49,0x3e,0x37,0x2c,0x25,0x1a,0x13,0x08,0x01,0xe6,0xef,0xf4,0xfd,0xc2,0xcb,0xd0,0xd9,0xae,0xa7,0xbc,0xb5,0x8a,0x83,0x98,0x91,0x4d,0x44,0x5f,0x56,0x69,0x60,0x7b,0x72,0x05,0x0c,0x17,0x1e,0x21,0x28,0x33,0x3a,0xdd,0xd4,0xcf,0xc6,0xf9,0xf0,0xeb,0xe2,0x95,0x9c,0x87,0x8e,0xb1,0xb8,0xa3,0xaa,0xec,0xe5,0xfe,0xf7,0xc8,0xc1,0xda,0xd3,0xa4,0xad,0xb6,0xbf,0x80,0x89,0x92,0x9b,0x7c,0x75,0x6e,0x67,0x58,0x51,0x4a,0x43,0x34,0x3d,0x26,0x2f,0x10,0x19,0x02,0x0b,0xd7,0xde,0xc5,0xcc,0xf3,0xfa,0xe1,0xe8,0x9f,0x96,0x8d,0x84,0xbb,0xb2,0xa9,0xa0,0x47,0x4e,0x55,0x5c,0x63,0x6a,0x71,0x78,0x0f,0x06,0x1d,0x14,0x2b,0x22,0x39,0x30,0x9a,0x93,0x88,0x81,0xbe,0xb7,0xac,0xa5,0xd2,0xdb,0xc0,0xc9,0xf6,0xff,0xe4,0xed,0x0a,0x03,0x18,0x11,0x2e,0x27,0x3c,0x35,0x42,0x4b,0x50,0x59,0x66,0x6f,0x74,0x7d,0xa1,0xa8,0xb3,0xba,0x85,0x8c,0x97,0x9e,0xe9,0xe0,0xfb,0xf2,0xcd,0xc4,0xdf,0xd6,0x31,0x38,0x23,0x2a,0x15,0x1c,0x07,0x0e,0x79,0x70,0x6b,0x62,0x5d,0x54,0x4f,0x46}
-var lookup_g11      = [...]byte{0x00,0x0b,0x16,0x1d,0x2c,0x27,0x3a,0x31,0x58,0x53,0x4e,0x45,0x74,0x7f,0x62,0x69,0xb0,0xbb,0xa6,0xad,0x9c,0x97,0x8a,0x81,0xe8,0xe3,0xfe,0xf5,0xc4,0xcf,0xd2,0xd9,0x7b,0x70,0x6d,0x66,0x57,0x5c,0x41,0x4a,0x23,0x28,0x35,0x3e,0x0f,0x04,0x19,0x12,0xcb,0xc0,0xdd,0xd6,0xe7,0xec,0xf1,0xfa,0x93,0x98,0x85,0x8e,0xbf,0xb4,0xa9,0xa2,0xf6,0xfd,0xe0,0xeb,0xda,0xd1,0xcc,0xc7,0xae,0xa5,0xb8,0xb3,0x82,0x89,0x94,0x9f,0x46,0x4d,0x50,0x5b,0x6a,0x61,0x7c,0x77,0x1e,0x15,0x08,0x03,0x32,0x39,0x24,0x2f,0x8d,0x86,0x9b,0x90,0xa1,0xaa,0xb7,0xbc,0xd5,0xde,0xc3,0xc8,0xf9,0xf2,0xef,0xe4,0x3d,0x36,0x2b,0x20,0x11,0x1a,0x07,0x0c,0x65,0x6e,0x73,0x78,0x49,0x42,0x5f,0x54,0xf7,0xfc,0xe1,0xea,0xdb,0xd0,0xcd,0xc6,0xaf,0xa4,0xb9,0xb2,0x83,0x88,0x95,0x9e,0x47,0x4c,0x51,0x5a,0x6b,0x60,0x7d,0x76,0x1f,0x14,0x09,0x02,0x33,0x38,0x25,0x2e,0x8c,0x87,0x9a,0x91,0xa0,0xab,0xb6,0xbd,0xd4,0xdf,0xc2,0xc9,0xf8,0xf3,0xee,0xe5,0x3c,0x37,0x2a,0x21,0x10,0x1b,0x06,0x0d,0x64,0x6f,0x72,0x79,0x48,0x43,0x5e,0x55,0x01,0x0a,0x17,0x1c,0x2d,0x26,0x3b,0x30,0x59,0x52,0x4f,0x44,0x75,0x7e,0x63,0x68,0xb1,0xba,0xa7,0xac,0x9d,0x96,0x8b,0x80,0xe9,0xe2,0xff,0xf4,0xc5,0xce,0xd3,0xd8,0x7a,0x71,0x6c,0x67,0x56,0x5d,0x40,0x4b,0x22,0x29,0x34,0x3f,0x0e,0x05,0x18,0x13,0xca,0xc1,0xdc,0xd7,0xe6,0xed,0xf0,0xfb,0x92,0x99,0x84,0x8f,0xbe,0xb5,0xa8,0xa3}
-var lookup_g13      = [...]byte{0x00,0x0d,0x1a,0x17,0x34,0x39,0x2e,0x23,0x68,0x65,0x72,0x7f,0x5c,0x51,0x46,0x4b,0xd0,0xdd,0xca,0xc7,0xe4,0xe9,0xfe,0xf3,0xb8,0xb5,0xa2,0xaf,0x8c,0x81,0x96,0x9b,0xbb,0xb6,0xa1,0xac,0x8f,0x82,0x95,0x98,0xd3,0xde,0xc9,0xc4,0xe7,0xea,0xfd,0xf0,0x6b,0x66,0x71,0x7c,0x5f,0x52,0x45,0x48,0x03,0x0e,0x19,0x14,0x37,0x3a,0x2d,0x20,0x6d,0x60,0x77,0x7a,0x59,0x54,0x43,0x4e,0x05,0x08,0x1f,0x12,0x31,0x3c,0x2b,0x26,0xbd,0xb0,0xa7,0xaa,0x89,0x84,0x93,0x9e,0xd5,0xd8,0xcf,0xc2,0xe1,0xec,0xfb,0xf6,0xd6,0xdb,0xcc,0xc1,0xe2,0xef,0xf8,0xf5,0xbe,0xb3,0xa4,0xa9,0x8a,0x87,0x90,0x9d,0x06,0x0b,0x1c,0x11,0x32,0x3f,0x28,0x25,0x6e,0x63,0x74,0x79,0x5a,0x57,0x40,0x4d,0xda,0xd7,0xc0,0xcd,0xee,0xe3,0xf4,0xf9,0xb2,0xbf,0xa8,0xa5,0x86,0x8b,0x9c,0x91,0x0a,0x07,0x10,0x1d,0x3e,0x33,0x24,0x29,0x62,0x6f,0x78,0x75,0x56,0x5b,0x4c,0x41,0x61,0x6c,0x7b,0x76,0x55,0x58,0x4f,0x42,0x09,0x04,0x13,0x1e,0x3d,0x30,0x27,0x2a,0xb1,0xbc,0xab,0xa6,0x85,0x88,0x9f,0x92,0xd9,0xd4,0xc3,0xce,0xed,0xe0,0xf7,0xfa,0xb7,0xba,0xad,0xa0,0x83,0x8e,0x99,0x94,0xdf,0xd2,0xc5,0xc8,0xeb,0xe6,0xf1,0xfc,0x67,0x6a,0x7d,0x70,0x53,0x5e,0x49,0x44,0x0f,0x02,0x15,0x18,0x3b,0x36,0x21,0x2c,0x0c,0x01,0x16,0x1b,0x38,0x35,0x22,0x2f,0x64,0x69,0x7e,0x73,0x50,0x5d,0x4a,0x47,0xdc,0xd1,0xc6,0xcb,0xe8,0xe5,0xf2,0xff,0xb4,0xb9,0xae,0xa3,0x80,0x8d,0x9a,0x97}
-var lookup_g14      = [...]byte{0x00,0x0e,0x1c,0x12,0x38,0x36,0x24,0x2a,0x70,0x7e,0x6c,0x62,0x48,0x46,0x54,0x5a,0xe0,0xee,0xfc,0xf2,0xd8,0xd6,0xc4,0xca,0x90,0x9e,0x8c,0x82,0xa8,0xa6,0xb4,0xba,0xdb,0xd5,0xc7,0xc9,0xe3,0xed,0xff,0xf1,0xab,0xa5,0xb7,0xb9,0x93,0x9d,0x8f,0x81,0x3b,0x35,0x27,0x29,0x03,0x0d,0x1f,0x11,0x4b,0x45,0x57,0x59,0x73,0x7d,0x6f,0x61,0xad,0xa3,0xb1,0xbf,0x95,0x9b,0x89,0x87,0xdd,0xd3,0xc1,0xcf,0xe5,0xeb,0xf9,0xf7,0x4d,0x43,0x51,0x5f,0x75,0x7b,0x69,0x67,0x3d,0x33,0x21,0x2f,0x05,0x0b,0x19,0x17,0x76,0x78,0x6a,0x64,0x4e,0x40,0x52,0x5c,0x06,0x08,0x1a,0x14,0x3e,0x30,0x22,0x2c,0x96,0x98,0x8a,0x84,0xae,0xa0,0xb2,0xbc,0xe6,0xe8,0xfa,0xf4,0xde,0xd0,0xc2,0xcc,0x41,0x4f,0x5d,0x53,0x79,0x77,0x65,0x6b,0x31,0x3f,0x2d,0x23,0x09,0x07,0x15,0x1b,0xa1,0xaf,0xbd,0xb3,0x99,0x97,0x85,0x8b,0xd1,0xdf,0xcd,0xc3,0xe9,0xe7,0xf5,0xfb,0x9a,0x94,0x86,0x88,0xa2,0xac,0xbe,0xb0,0xea,0xe4,0xf6,0xf8,0xd2,0xdc,0xce,0xc0,0x7a,0x74,0x66,0x68,0x42,0x4c,0x5e,0x50,0x0a,0x04,0x16,0x18,0x32,0x3c,0x2e,0x20,0xec,0xe2,0xf0,0xfe,0xd4,0xda,0xc8,0xc6,0x9c,0x92,0x80,0x8e,0xa4,0xaa,0xb8,0xb6,0x0c,0x02,0x10,0x1e,0x34,0x3a,0x28,0x26,0x7c,0x72,0x60,0x6e,0x44,0x4a,0x58,0x56,0x37,0x39,0x2b,0x25,0x0f,0x01,0x13,0x1d,0x47,0x49,0x5b,0x55,0x7f,0x71,0x63,0x6d,0xd7,0xd9,0xcb,0xc5,0xef,0xe1,0xf3,0xfd,0xa7,0xa9,0xbb,0xb5,0x9f,0x91,0x83,0x8d}
-
-// Convert a string eg 85E5A3D7356A61E29A8AFA559AD67102 into an array of bytes
-func to_bytes(s string) []byte {
-  l := len(s)/2
-  b := make([]byte,l)
-  for i:=0;i<l;i++ {
-    fmt.Sscanf(s[i*2:i*2+2],"%x", &b[i])
+import "fmt"           // For printf
+import "errors"        // For the errors from the attacks and tools
+import "crypto/subtle" // For comparing keys and ciphertexts in constant time
+import "encoding/hex"  // For reading round keys from the command line
+import "os"            // For the command line tools
+import "strings"       // For tidying the trace grids
+import "bufio"         // For reading power traces
+import "io"            // For reading and writing power traces
+import "strconv"       // For the schedule command's round number, and reading power traces
+import "math"          // For the correlations in power analysis
+import mrand "math/rand" // For the noise in simulated power traces
+
+// Renders the encryption and decryption of a block as text, in the same format as FIPS-197 Appendix C
+// eg "round[ 1].s_box 63cab7040953d051cd60e0e7ba70e18c", so the output can be diffed against the spec
+func aes_trace_text(m []byte, k []byte) (s string) {
+  line := func(round int, step string, state []byte) {
+    s += fmt.Sprintf("round[%2d].%-8s%x\r\n", round, step, state)
   }
-  return b
+  s += fmt.Sprintf("PLAINTEXT:      %x\r\n", m)
+  s += fmt.Sprintf("KEY:            %x\r\n", k)
+  s += "CIPHER (ENCRYPT):\r\n"
+  c := encrypt_traced(m, k, line)
+  s += "INVERSE CIPHER (DECRYPT):\r\n"
+  decrypt_traced(c[0:], k, line)
+  return
 }
 
-// Pretty-print an array
-func pretty(label string, arr []byte) {
-  var s string=""
-  for i,b := range arr {
-    s += fmt.Sprintf("%02X",b)
-    if i<len(arr)-1 {
-      s += "-"
+// Renders the encryption of a block as 4x4 grids, in the same layout as the "cipher example" table in FIPS-197 Appendix B
+// The state fills the grid a column at a time, so byte n is in row n%4, column n/4
+func aes_trace_grid(m []byte, k []byte) (s string) {
+  // Collect the grids for each round: start of round, after sub bytes, after shift rows, after mix columns, round key
+  var grids [11][5][]byte
+  columns := map[string]int{"input": 0, "start": 0, "s_box": 1, "s_row": 2, "m_col": 3, "k_sch": 4}
+  c := encrypt_traced(m, k, func(round int, step string, state []byte) {
+    if col, ok := columns[step]; ok {
+      grids[round][col] = append([]byte{}, state...) // Copy it, as the state keeps changing
     }
-  }
-  fmt.Printf("%s:\r\n%s\r\n", label, s)
-}
+  })
 
-// Apply and reverse the rijndael s-box to all elements in an array
-// http://en.wikipedia.org/wiki/Rijndael_S-box
-func sub_bytes(a []byte) {
-  for i:=0;i<len(a);i++ {
-    a[i]=lookup_sbox[a[i]]
-  }
-}
-func sub_bytes_inv(a []byte) {
-  for i:=0;i<len(a);i++ {
-    a[i]=lookup_sbox_inv[a[i]]
-  }
-}
-
-// Perform the core key schedule transform on 4 bytes, as part of the key expansion process
-// http://en.wikipedia.org/wiki/Rijndael_key_schedule#Key_schedule_core
-func key_schedule_core(a *[4]byte, i int) { 
-  temp := a[0]         // Rotate the output eight bits to the left
-  a[0]=a[1]
-  a[1]=a[2]
-  a[2]=a[3]
-  a[3]=temp
-  sub_bytes(a[0:])     // Apply Rijndael's S-box on all four individual bytes in the output word
-  a[0]^=lookup_rcon[i] // On just the first (leftmost) byte of the output word, perform the rcon operation with i
-                       // as the input, and exclusive or the rcon output with the first byte of the output word
-}
-
-// Xor's all elements in a 4 byte array a by b
-func xor4(a *[4]byte, b []byte) {
-  a[0] ^= b[0]
-  a[1] ^= b[1]
-  a[2] ^= b[2]
-  a[3] ^= b[3]
-}
-
-// Expand the 128-bit key to 11 round keys
-// http://en.wikipedia.org/wiki/Rijndael_key_schedule#The_key_schedule
-func expand_key(key []byte) (keys [176]byte) {
-  copy(keys[0:16],key)  // The first 16 bytes of the expanded key are simply the encryption key
-  bytes:=16             // The count of how many bytes we've created so far
-  i:=1                  // The rcon iteration value i is set to 1
-  var t [4]byte         // Temporary working area known as 't' in the Wiki article
-  for bytes<176 {       // Until we have 176 bytes of expanded key, we do the following:
-    copy(t[0:4],keys[bytes-4:bytes])   // We assign the value of the previous four bytes in the expanded key to t
-    key_schedule_core(&t, i)           // We perform the key schedule core on t, with i as the rcon iteration value
-    i++                                // We increment i by 1
-    xor4(&t,keys[bytes-16:bytes-16+4]) // We exclusive-or t with the four-byte block 16 bytes before the new expanded key. 
-    copy(keys[bytes:],t[0:])           // This becomes the next 4 bytes in the expanded key
-    bytes+=4                           // Keep track of how many expanded key bytes we've added
-
-    // We then do the following three times to create the next twelve bytes
-    for j:=0;j<3;j++ {
-      copy(t[0:],keys[bytes-4:bytes])    // We assign the value of the previous 4 bytes in the expanded key to t
-      xor4(&t,keys[bytes-16:bytes-16+4]) // We exclusive-or t with the four-byte block n bytes before
-      copy(keys[bytes:],t[0:])           // This becomes the next 4 bytes in the expanded key
-      bytes+=4                           // Keep track of how many expanded key bytes we've added
+  // Draw them out, one row of each grid per line
+  grid_row := func(grid []byte, row int) string {
+    if grid == nil {
+      return "             " // Blank, eg the mix columns that the final round skips
     }
+    return fmt.Sprintf("%02x %02x %02x %02x  ", grid[row], grid[row+4], grid[row+8], grid[row+12])
+  }
+  s += "Round   Start of     After        After        After        Round Key\r\n"
+  s += "Number  Round        SubBytes     ShiftRows    MixColumns   Value\r\n"
+  for round:=0; round<=10; round++ {
+    for row:=0; row<4; row++ {
+      label := ""
+      if row==1 && round==0 {
+        label = "input"
+      } else if row==1 {
+        label = fmt.Sprintf("%d", round)
+      }
+      line := fmt.Sprintf("%-8s", label)
+      for col:=0; col<5; col++ {
+        line += grid_row(grids[round][col], row)
+      }
+      s += strings.TrimRight(line, " ") + "\r\n"
+    }
+    s += "\r\n"
+  }
+  for row:=0; row<4; row++ {
+    label := ""
+    if row==1 {
+      label = "output"
+    }
+    s += strings.TrimRight(fmt.Sprintf("%-8s%s", label, grid_row(c[0:], row)), " ") + "\r\n"
   }
   return
 }
 
-// Xor the current cipher state by a specific round key
-func xor_round_key(state []byte, keys []byte, round int) {
-  for i:=0;i<16;i++ {
-    state[i] ^= keys[i+round*16]
+// AES-CMAC (NIST SP 800-38B / RFC 4493) with a 16, 24 or 32 byte key, giving a 16 byte MAC
+// This is CBC-MAC with a twist: the last block is xor'd with a subkey so that messages can't be extended
+func cmac(m []byte, k []byte) ([]byte, error) {
+  keys,err := key_schedule(k,0,len(k))
+  if err != nil {
+    return nil, err
   }
+  zero := encrypt(make([]byte,16),keys) // The subkeys come from encrypting zeros
+  k1 := cmac_double(zero[0:], 0x87)
+  k2 := cmac_double(k1, 0x87)
+
+  // A complete last block gets xor'd with K1, an incomplete (or empty) one gets padded with 80 00 00.. and xor'd with K2
+  n := (len(m)+15)/16 // Number of blocks
+  if n==0 {
+    n=1
+  }
+  var last []byte
+  if len(m)>0 && len(m)%16==0 {
+    last = xor(m[len(m)-16:],k1)
+  } else {
+    last = make([]byte,16)
+    copy(last,m[(n-1)*16:])
+    last[len(m)-(n-1)*16] = 0x80
+    last = xor(last,k2)
+  }
+
+  // Now it's just CBC encryption with a zero IV, keeping only the final block
+  var mac [16]byte
+  for i:=0;i<n-1;i++ {
+    mac = encrypt(xor(mac[0:],m[i*16:i*16+16]),keys)
+  }
+  mac = encrypt(xor(mac[0:],last),keys)
+  return mac[0:], nil
 }
 
-// Apply / reverse the shift rows step on the 16 byte cipher state
-// http://en.wikipedia.org/wiki/Advanced_Encryption_Standard#The_ShiftRows_step
-func shift_rows (state []byte) {
-  var temp [16]byte
-  copy(temp[0:],state)
-  for i:=0;i<16;i++ {
-    state[i]=temp[shift_rows_table[i]]
+// TR-31 version 'D' key blocks are protected by AES, with a 128, 192 or 256 bit KBPK. The rest is in shared_tr31.go
+var tr31_d = tr31_version{'D', 16, tr31_derive_d, cmac, tr31_cbc_encrypt_d, tr31_cbc_decrypt_d}
+
+// Derives the key block encryption key (KBEK) and MAC key (KBMK) from a 128, 192 or 256 bit AES KBPK
+// This uses CMAC as the pseudo-random function in a counter mode KDF (NIST SP 800-108), with 8 bytes of derivation data:
+// counter | key usage (0000 encryption, 0001 MAC) | 00 separator | algorithm | key length in bits
+// The algorithm and length are 0002 0080 for AES-128, 0003 00C0 for AES-192, and 0004 0100 for AES-256
+// Each CMAC gives 16 bytes, so the bigger keys are made from two with counter 1 and 2, cut down to the KBPK's size
+func tr31_derive_d(kbpk []byte) (kbek []byte, kbmk []byte, err error) {
+  algorithm := map[int]byte{16: 2, 24: 3, 32: 4}[len(kbpk)]
+  if algorithm==0 {
+    return nil, nil, errors.New("tr31: version D KBPK must be 16, 24 or 32 bytes")
   }
+  bits := len(kbpk)*8
+  for counter:=byte(1);len(kbek)<len(kbpk);counter++ {
+    var e,m []byte
+    if e,err = cmac([]byte{counter,0,0,0,0,algorithm,byte(bits>>8),byte(bits)},kbpk); err != nil {
+      return
+    }
+    if m,err = cmac([]byte{counter,0,1,0,0,algorithm,byte(bits>>8),byte(bits)},kbpk); err != nil {
+      return
+    }
+    kbek,kbmk = join(kbek,e),join(kbmk,m)
+  }
+  return kbek[:len(kbpk)], kbmk[:len(kbpk)], nil
 }
-func shift_rows_inv (state []byte) {
-  var temp [16]byte
-  copy(temp[0:],state)
-  for i:=0;i<16;i++ {
-    state[i]=temp[shift_rows_table_inv[i]]
+
+// AES CBC mode for version 'D', taking the key before it's expanded
+func tr31_cbc_encrypt_d(m []byte, key []byte, iv []byte) ([]byte, error) {
+  keys,err := key_schedule(key,0,len(key))
+  if err != nil {
+    return nil, err
   }
+  return cbc_encrypt(m,keys,iv), nil
+}
+func tr31_cbc_decrypt_d(c []byte, key []byte, iv []byte) ([]byte, error) {
+  keys,err := key_schedule(key,0,len(key))
+  if err != nil {
+    return nil, err
+  }
+  return cbc_decrypt(c,keys,iv), nil
 }
 
-// Perform the mix columns matrix on one column of 4 bytes
-// http://en.wikipedia.org/wiki/Rijndael_mix_columns
-func mix_col (state []byte) {
-  a0 := state[0]
-  a1 := state[1]
-  a2 := state[2]
-  a3 := state[3]
-  state[0] = lookup_g2[a0] ^ lookup_g3[a1] ^ a2 ^ a3
-  state[1] = lookup_g2[a1] ^ lookup_g3[a2] ^ a3 ^ a0
-  state[2] = lookup_g2[a2] ^ lookup_g3[a3] ^ a0 ^ a1
-  state[3] = lookup_g2[a3] ^ lookup_g3[a0] ^ a1 ^ a2
-}
-
-// Perform the mix columns matrix on each column of the 16 bytes
-func mix_cols (state []byte) {
-  mix_col(state[0:4])
-  mix_col(state[4:8])
-  mix_col(state[8:12])
-  mix_col(state[12:16])
-}
-
-// Perform the inverse mix columns matrix on one column of 4 bytes
-// http://en.wikipedia.org/wiki/Rijndael_mix_columns
-func mix_col_inv (state []byte) {
-  a0 := state[0]
-  a1 := state[1]
-  a2 := state[2]
-  a3 := state[3]
-  state[0] = lookup_g14[a0] ^ lookup_g9[a3] ^ lookup_g13[a2] ^ lookup_g11[a1]
-  state[1] = lookup_g14[a1] ^ lookup_g9[a0] ^ lookup_g13[a3] ^ lookup_g11[a2]
-  state[2] = lookup_g14[a2] ^ lookup_g9[a1] ^ lookup_g13[a0] ^ lookup_g11[a3]
-  state[3] = lookup_g14[a3] ^ lookup_g9[a2] ^ lookup_g13[a1] ^ lookup_g11[a0]
-}
-
-// Perform the inverse mix columns matrix on each column of the 16 bytes
-func mix_cols_inv (state []byte) {
-  mix_col_inv(state[0:4])
-  mix_col_inv(state[4:8])
-  mix_col_inv(state[8:12])
-  mix_col_inv(state[12:16])
-}
-
-// Encrypt a single 128 bit block by a 128 bit key using AES
-// http://en.wikipedia.org/wiki/Advanced_Encryption_Standard
-func encrypt(m []byte, k []byte) (c [16]byte) {
-  // Key expansion
-  keys := expand_key(k)
+// The Square attack (Daemen, Knudsen and Rijmen, 1997), also known as the integral attack, breaks AES cut down to
+// 4 rounds with 256 chosen plaintexts per lambda set. A lambda set is 256 plaintexts that are all the same except
+// for one byte, which takes every value. Going through the rounds:
+//  Round 1: sub bytes keeps the one byte taking every value, and mix columns spreads it over a whole column
+//  Round 2: shift rows spreads the column over all 4 columns, so now every byte takes every value
+//  Round 3: mix columns mixes bytes that each take every value, so each output byte xor's to 0 over the 256 blocks
+//           (it's 'balanced'), as do the bytes after adding the round key
+//  Round 4: there's no mix columns in the last round, so each ciphertext byte only depends on one balanced byte
+// So a guess for a byte of the round 4 key can be checked by undoing the round for that byte of all 256 ciphertexts,
+// and seeing if the results xor to 0. Wrong guesses pass 1 time in 256, so a second lambda set weeds them out.
+// Once all 16 bytes of the round 4 key are known, running the key schedule backwards gives the original key
+// Reference: http://en.wikipedia.org/wiki/Integral_cryptanalysis
 
-  // First Round
+// AES cut down to the given number of rounds (1 to 10) for cryptanalysis. The last round leaves out mix columns as
+// usual, so with all 10 rounds it's exactly encrypt
+func encrypt_rounds(m []byte, k []byte, rounds int) (c [16]byte) {
+  keys := expand_key(k)
   copy(c[0:],m)
   xor_round_key(c[0:], keys[0:], 0)
-
-  // Middle rounds
-  for i:=0; i<9; i++ {
+  for i:=1; i<=rounds; i++ {
     sub_bytes(c[0:])
     shift_rows(c[0:])
-    mix_cols(c[0:])
-    xor_round_key(c[0:], keys[0:], i+1)
+    if i<rounds {
+      mix_cols(c[0:])
+    }
+    xor_round_key(c[0:], keys[0:], i)
+  }
+  return
+}
+
+// Runs the key schedule backwards, from the round key of any round (0 to 10) to the original 16 byte key
+func invert_key_schedule(round_key []byte, round int) []byte {
+  keys,_ := key_schedule(round_key,round,16)
+  return keys[0:16]
+}
+
+// Recovers the key of 4 round AES given a chosen plaintext oracle, with the Square attack described above
+// Returns the original key, and how many lambda sets it took
+func square_attack(encrypt block_cipher) (key []byte, sets int, err error) {
+  // Every byte of the round 4 key starts with all 256 candidates
+  var candidates [16][]byte
+  for pos:=0;pos<16;pos++ {
+    for g:=0;g<256;g++ {
+      candidates[pos] = append(candidates[pos],byte(g))
+    }
   }
 
-  // Final Round
-  sub_bytes(c[0:])
-  shift_rows(c[0:])
-  xor_round_key(c[0:], keys[0:], 10)
+  // Keep checking against new lambda sets until each byte is down to one candidate
+  for remaining:=256*16;remaining>16;sets++ {
+    if sets==8 {
+      err = errors.New("square: too many candidates left, is it really 4 round AES?")
+      return
+    }
+
+    // Encrypt a lambda set: random constant bytes, and byte 0 taking every value
+    m := random_bytes(16)
+    var set [256][]byte
+    for v:=0;v<256;v++ {
+      m[0] = byte(v)
+      set[v] = encrypt(m)
+    }
 
+    // For each byte, keep the guesses where undoing round 4 gives bytes that xor to 0
+    remaining = 0
+    for pos:=0;pos<16;pos++ {
+      var kept []byte
+      for _,g := range candidates[pos] {
+        var sum byte
+        for v:=0;v<256;v++ {
+          sum ^= lookup_sbox_inv[set[v][pos]^g]
+        }
+        if sum==0 {
+          kept = append(kept,g)
+        }
+      }
+      if len(kept)==0 {
+        err = errors.New("square: no round key byte balances, is it really 4 round AES?")
+        return
+      }
+      candidates[pos] = kept
+      remaining += len(kept)
+    }
+  }
+
+  round_key := make([]byte,16)
+  for pos:=0;pos<16;pos++ {
+    round_key[pos] = candidates[pos][0]
+  }
+  key = invert_key_schedule(round_key,4)
   return
 }
 
-// Decrypt a single 128 bit block by a 128 bit key using AES
-// http://en.wikipedia.org/wiki/Advanced_Encryption_Standard
-func decrypt(c []byte, k []byte) (m [16]byte) {
-  // Key expansion
-  keys := expand_key(k)
-  
-  // Reverse the final Round
-  copy(m[0:],c)
-  xor_round_key(m[0:], keys[0:], 10)
-  shift_rows_inv(m[0:])
-  sub_bytes_inv(m[0:])
-  
-  // Reverse the middle rounds
-  for i:=0; i<9; i++ {
-    xor_round_key(m[0:], keys[0:], 9-i)
-    mix_cols_inv(m[0:])
-    shift_rows_inv(m[0:])
-    sub_bytes_inv(m[0:])
+// Demonstrates the Square attack on 4 round AES, against a random key
+func square_demo() {
+  secret := random_bytes(16)
+  oracle := func(m []byte) []byte {
+    c := encrypt_rounds(m,secret,4)
+    return c[0:]
   }
-  
-  // Reverse the first Round
-  xor_round_key(m[0:], keys[0:], 0)
-  
+  key,sets,err := square_attack(oracle)
+  if err != nil {
+    fmt.Printf("%s\r\n", err)
+    return
+  }
+  keys := expand_key(secret)
+  pretty("Round 4 key", keys[64:80])
+  pretty("Recovered key", key)
+  pretty("Actual key", secret)
+  fmt.Printf("Using %d lambda sets of 256 chosen plaintexts\r\n", sets)
+  if subtle.ConstantTimeCompare(key,secret)==1 {
+    println("Key recovered")
+  } else {
+    println("Wrong key")
+  }
+}
+
+// A simulated side channel lab: correlation power analysis (Brier, Clavier and Olivier, 2004) against the S-box.
+// Real chips use more power switching more bits, so the power drawn while sub bytes writes its output follows the
+// Hamming weight (number of 1 bits) of that output. Here that's simulated: encrypting through the tracer hook, the
+// first round's sub bytes input and output bytes become 32 'power samples', each the Hamming weight plus Gaussian
+// noise. The attacker only sees the plaintexts and the samples, and for each key byte guesses all 256 values:
+// the right guess predicts Hamming weights of S(p^k) that correlate with one of the samples, wrong guesses don't.
+// Reference: http://en.wikipedia.org/wiki/Power_analysis
+
+// One recorded encryption: what went in and out, and the simulated power samples
+type power_trace struct {
+  plaintext  []byte
+  ciphertext []byte
+  samples    []float64
+}
+
+// Counts the 1 bits in a byte
+func hamming_weight(b byte) (w int) {
+  for ;b>0;b>>=1 {
+    w += int(b&1)
+  }
+  return
+}
+
+// Encrypts a random plaintext, recording the Hamming weight of the state bytes going into and coming out of
+// round 1's sub bytes, plus Gaussian noise with the given standard deviation
+func record_trace(k []byte, noise float64) (t power_trace) {
+  t.plaintext = random_bytes(16)
+  c := encrypt_traced(t.plaintext, k, func(round int, step string, state []byte) {
+    if round==1 && (step=="start" || step=="s_box") {
+      for _,b := range state {
+        t.samples = append(t.samples, float64(hamming_weight(b)) + mrand.NormFloat64()*noise)
+      }
+    }
+  })
+  t.ciphertext = c[0:]
   return
 }
 
+// Writes traces in a simple text format: a comment line, then a line per trace with the plaintext and
+// ciphertext in hex, and the samples, all separated by spaces
+func write_traces(w io.Writer, traces []power_trace) error {
+  if _,err := fmt.Fprintf(w, "# plaintext ciphertext samples...\n"); err != nil {
+    return err
+  }
+  for _,t := range traces {
+    line := fmt.Sprintf("%x %x", t.plaintext, t.ciphertext)
+    for _,s := range t.samples {
+      line += fmt.Sprintf(" %.3f", s)
+    }
+    if _,err := fmt.Fprintf(w, "%s\n", line); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Reads traces written by write_traces, skipping blank and comment lines
+func read_traces(r io.Reader) (traces []power_trace, err error) {
+  scanner := bufio.NewScanner(r)
+  scanner.Buffer(nil, 1<<20)
+  for line:=1;scanner.Scan();line++ {
+    fields := strings.Fields(scanner.Text())
+    if len(fields)==0 || strings.HasPrefix(fields[0],"#") {
+      continue
+    }
+    var t power_trace
+    if len(fields)<3 {
+      return nil, fmt.Errorf("traces: line %d: expected plaintext, ciphertext and samples", line)
+    }
+    if t.plaintext,err = parse_hex(fields[0],16); err == nil {
+      t.ciphertext,err = parse_hex(fields[1],16)
+    }
+    for _,f := range fields[2:] {
+      if err != nil {
+        break
+      }
+      var s float64
+      s,err = strconv.ParseFloat(f,64)
+      t.samples = append(t.samples,s)
+    }
+    if err == nil && len(traces)>0 && len(t.samples)!=len(traces[0].samples) {
+      err = errors.New("not the same number of samples as the first trace")
+    }
+    if err != nil {
+      return nil, fmt.Errorf("traces: line %d: %s", line, err)
+    }
+    traces = append(traces,t)
+  }
+  if err = scanner.Err(); err == nil && len(traces)==0 {
+    err = errors.New("traces: no traces found")
+  }
+  return
+}
+
+// Recovers the key byte by byte from the traces. For each byte and each guess at it, predicts the Hamming weight of
+// the S-box output in every trace, and correlates that against every sample. The guess with the strongest
+// correlation wins. Returns the key, and for each byte the winning correlation, the sample it was found at, and the
+// strongest correlation of any wrong guess, to show how clear the win was
+func cpa_attack(traces []power_trace) (key []byte, best [16]float64, at [16]int, runner_up [16]float64) {
+  key = make([]byte,16)
+
+  // Pull out the columns of samples, to correlate against
+  columns := make([][]float64,len(traces[0].samples))
+  for j := range columns {
+    for _,t := range traces {
+      columns[j] = append(columns[j],t.samples[j])
+    }
+  }
+
+  guess := make([]float64,len(traces))
+  for b:=0;b<16;b++ {
+    for g:=0;g<256;g++ {
+      for i,t := range traces {
+        guess[i] = float64(hamming_weight(lookup_sbox[t.plaintext[b]^byte(g)]))
+      }
+      strongest, sample := 0.0, 0
+      for j := range columns {
+        if c := math.Abs(correlation(guess,columns[j])); c>strongest {
+          strongest, sample = c, j
+        }
+      }
+      if strongest>best[b] {
+        runner_up[b] = best[b]
+        key[b], best[b], at[b] = byte(g), strongest, sample
+      } else if strongest>runner_up[b] {
+        runner_up[b] = strongest
+      }
+    }
+  }
+  return
+}
+
+// Runs the CPA attack on a trace file and reports on each key byte
+func cpa_report(traces []power_trace) {
+  key,best,at,runner_up := cpa_attack(traces)
+  fmt.Printf("%d traces of %d samples\r\n", len(traces), len(traces[0].samples))
+  fmt.Printf("Byte  Key  Correlation  Sample  Best wrong guess\r\n")
+  for b:=0;b<16;b++ {
+    fmt.Printf("%4d   %02X  %11.3f  %6d  %16.3f\r\n", b, key[b], best[b], at[b], runner_up[b])
+  }
+  pretty("Recovered key", key)
+  c := encrypt(traces[0].plaintext,expand_key(key))
+  if subtle.ConstantTimeCompare(c[0:],traces[0].ciphertext)==1 {
+    println("Key checks out against the first trace's ciphertext")
+  } else {
+    println("Key doesn't match the first trace's ciphertext, try more traces")
+  }
+}
+
+// Fault injection: glitching a chip's clock or power, or firing a laser at it, can flip bits of the cipher state
+// while it's encrypting. Here the faults are simulated through the tracer hook, which gets the live state and so can
+// change it. Comparing a correct ciphertext with a faulty one of the same plaintext leaks the key: with Piret and
+// Quisquater's differential fault analysis (2003), a byte fault going into round 9 gives away 4 bytes of the round 10
+// key, so about 8 faulty encryptions give the whole key.
+// Reference: http://en.wikipedia.org/wiki/Differential_fault_analysis
+
+// A fault to inject: at the start of the given round (1 to 10), before sub bytes, xor the mask into one byte of the
+// state. A mask with 1 bit set is a bit fault, any other nonzero mask is a byte fault
+type fault struct {
+  round    int
+  position int
+  mask     byte
+}
+
+// Encrypts with the faults injected. With verify set, it also decrypts the result and checks it gives back the
+// message: the encrypt-then-decrypt countermeasure. A fault would have to hit the decryption in exactly the
+// right way to get past it, so instead of the faulty ciphertext, the caller gets an error
+func encrypt_faulty(m []byte, k []byte, faults []fault, verify bool) (c [16]byte, err error) {
+  c = encrypt_traced(m, k, func(round int, step string, state []byte) {
+    for _,f := range faults {
+      if round==f.round && step=="start" {
+        state[f.position] ^= f.mask
+      }
+    }
+  })
+  if verify {
+    if check := decrypt(c[0:],expand_key(k)); subtle.ConstantTimeCompare(check[0:],m)!=1 {
+      return [16]byte{}, errors.New("fault detected: the ciphertext doesn't decrypt back to the message")
+    }
+  }
+  return
+}
+
+// Multiplies by 1, 2 or 3 in the galois field, as mix columns does
+func gmul(coefficient int, b byte) byte {
+  switch coefficient {
+  case 2:
+    return lookup_g2[b]
+  case 3:
+    return lookup_g3[b]
+  }
+  return b
+}
+
+// The ciphertext bytes that a column of the state at the end of round 9 ends up in, after round 10's shift rows
+func dfa_positions(column int) (positions [4]int) {
+  for j:=0;j<16;j++ {
+    if int(shift_rows_table[j])/4==column {
+      positions[shift_rows_table[j]%4] = j
+    }
+  }
+  return
+}
+
+// Works out which 4 bytes of the round 10 key a pair of correct and faulty ciphertexts can tell us about, and all
+// the guesses at those bytes that fit. A byte fault going into round 9 reaches a single byte of one column before
+// round 9's mix columns, which turns a difference d in row r into a difference of d times 2, 3, 1 and 1 (rotated by r)
+// down that column. Round 10 has no mix columns, so undoing it for each of those 4 ciphertext bytes with a guessed key
+// byte must give those differences, for some d and r. Returns the column, or -1 if it's not that kind of fault
+func dfa_candidates(c []byte, faulty []byte) (column int, candidates map[[4]byte]bool) {
+  // Which column differs: it has to be all 4 bytes of exactly one column
+  column = -1
+  for col:=0;col<4;col++ {
+    differs := 0
+    for _,pos := range dfa_positions(col) {
+      if c[pos]!=faulty[pos] {
+        differs++
+      }
+    }
+    if differs==4 && column==-1 {
+      column = col
+    } else if differs!=0 {
+      return -1, nil
+    }
+  }
+  if column==-1 {
+    return
+  }
+
+  candidates = make(map[[4]byte]bool)
+  positions := dfa_positions(column)
+  for r:=0;r<4;r++ {
+    for d:=1;d<256;d++ {
+      // The key byte guesses that fit each row separately
+      var fits [4][]byte
+      for i:=0;i<4;i++ {
+        want := gmul([]int{2,3,1,1}[(r-i+4)%4],byte(d)) // Row i of the mix columns matrix times row r of the fault
+        for k:=0;k<256;k++ {
+          if lookup_sbox_inv[c[positions[i]]^byte(k)]^lookup_sbox_inv[faulty[positions[i]]^byte(k)]==want {
+            fits[i] = append(fits[i],byte(k))
+          }
+        }
+      }
+      // Every combination of them fits the whole column
+      for _,k0 := range fits[0] {
+        for _,k1 := range fits[1] {
+          for _,k2 := range fits[2] {
+            for _,k3 := range fits[3] {
+              candidates[[4]byte{k0,k1,k2,k3}] = true
+            }
+          }
+        }
+      }
+    }
+  }
+  return
+}
+
+// Piret and Quisquater's attack: narrows down each column's 4 round 10 key bytes by keeping only the guesses that fit
+// every pair of correct and faulty ciphertexts for that column, until there's one left for each column. Pairs that
+// aren't from a round 9 byte fault are ignored. Returns the original key, worked back from the round 10 key
+func dfa_attack(correct [][]byte, faulty [][]byte) (key []byte, err error) {
+  var columns [4]map[[4]byte]bool
+  for i := range correct {
+    column,candidates := dfa_candidates(correct[i],faulty[i])
+    if column==-1 {
+      continue
+    }
+    if columns[column]==nil {
+      columns[column] = candidates
+    } else {
+      for guess := range columns[column] {
+        if !candidates[guess] {
+          delete(columns[column],guess)
+        }
+      }
+    }
+  }
+
+  round_key := make([]byte,16)
+  for col:=0;col<4;col++ {
+    if len(columns[col])!=1 {
+      return nil, fmt.Errorf("dfa: column %d has %d candidates, more faults are needed", col, len(columns[col]))
+    }
+    for guess := range columns[col] {
+      for i,pos := range dfa_positions(col) {
+        round_key[pos] = guess[i]
+      }
+    }
+  }
+  return invert_key_schedule(round_key,10), nil
+}
+
+// Demonstrates the countermeasure, then collects faulty ciphertexts from random byte faults going into round 9 until
+// Piret and Quisquater's attack recovers the key
+func dfa_demo() {
+  secret := random_bytes(16)
+  m := random_bytes(16)
+  f := fault{9,int(random_bytes(1)[0]%16),1}
+  _,err := encrypt_faulty(m,secret,[]fault{f},true)
+  fmt.Printf("Bit fault in round 9 with the countermeasure: %v\r\n", err)
+
+  var correct,faulty [][]byte
+  for len(faulty)<64 {
+    m = random_bytes(16)
+    f = fault{9,int(random_bytes(1)[0]%16),random_bytes(1)[0]|1} // |1 so the mask isn't 0
+    c := encrypt(m,expand_key(secret))
+    c_faulty,_ := encrypt_faulty(m,secret,[]fault{f},false)
+    correct = append(correct,c[0:])
+    faulty = append(faulty,c_faulty[0:])
+    if key,err := dfa_attack(correct,faulty); err == nil {
+      fmt.Printf("Recovered the key from %d faulty ciphertexts without the countermeasure\r\n", len(faulty))
+      pretty("Recovered key", key)
+      pretty("Actual key", secret)
+      return
+    }
+  }
+  println("Couldn't recover the key")
+}
+
 // Test the AES implementation
 // This should output the original message, encrypt it, then decrypt it again
+// Also has some command line tools:
+//  go_aes trace key msg - Show every step of encrypting and decrypting, as in FIPS-197 Appendix C
+//  go_aes grid key msg - Show every round of encrypting as grids, as in FIPS-197 Appendix B
+//  go_aes square - Break 4 round AES with the Square attack
+//  go_aes schedule round words - Show the whole key schedule for a 16, 24 or 32 byte key, given the key (round 0)
+//    or the same number of bytes from the schedule starting at any round. eg the round 10 key gives back the key:
+//    go_aes schedule 10 d014f9a8c9ee2589e13f0cc8b6630ca6
+//  go_aes traces n noise key - Write n simulated power traces of encrypting random blocks to stdout, with Gaussian
+//    noise of the given standard deviation, eg go_aes traces 300 2.0 2b7e151628aed2a6abf7158809cf4f3c > traces.txt
+//  go_aes cpa traces.txt - Recover the key from a file of power traces by correlation power analysis
+//  go_aes fault round position mask key msg - Encrypt with the mask xor'd into a byte of the state at the start of
+//    a round, eg go_aes fault 9 0 01 2b7e151628aed2a6abf7158809cf4f3c 3243f6a8885a308d313198a2e0370734
+//  go_aes dfa - Recover a random key from faulty ciphertexts by differential fault analysis
+//  eg: go_aes grid 2b7e151628aed2a6abf7158809cf4f3c 3243f6a8885a308d313198a2e0370734
 func main() {
+  if len(os.Args)==2 && os.Args[1]=="square" {
+    square_demo()
+    return
+  }
+  if len(os.Args)==5 && os.Args[1]=="traces" {
+    n,err := strconv.Atoi(os.Args[2])
+    var noise float64
+    var k []byte
+    if err == nil && n<1 {
+      err = errors.New("traces: the number of traces must be at least 1")
+    }
+    if err == nil {
+      if noise,err = strconv.ParseFloat(os.Args[3],64); err == nil {
+        k,err = parse_hex(os.Args[4],16)
+      }
+    }
+    if err == nil {
+      traces := make([]power_trace,n)
+      for i := range traces {
+        traces[i] = record_trace(k,noise)
+      }
+      err = write_traces(os.Stdout,traces)
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    return
+  }
+  if len(os.Args)==3 && os.Args[1]=="cpa" {
+    file,err := os.Open(os.Args[2])
+    var traces []power_trace
+    if err == nil {
+      traces,err = read_traces(file)
+      file.Close()
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    cpa_report(traces)
+    return
+  }
+  if len(os.Args)==2 && os.Args[1]=="dfa" {
+    dfa_demo()
+    return
+  }
+  if len(os.Args)==4 && os.Args[1]=="schedule" {
+    round,err := strconv.Atoi(os.Args[2])
+    var words,keys []byte
+    if err == nil {
+      if words,err = hex.DecodeString(os.Args[3]); err == nil {
+        keys,err = key_schedule(words,round,len(words))
+      }
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    fmt.Printf("Key: %X\r\n", keys[0:len(words)])
+    for i:=0;i<len(keys)/16;i++ {
+      fmt.Printf("Round %2d: %X\r\n", i, keys[i*16:i*16+16])
+    }
+    return
+  }
+  if len(os.Args)>1 {
+    var k,m []byte
+    var err error
+    n := len(os.Args)
+    if n<4 || n>7 {
+      err = errors.New("unknown command, see the comment above main() for usage")
+    } else if k,err = parse_hex(os.Args[n-2],16); err == nil { // The key and message are always the last two arguments
+      m,err = parse_hex(os.Args[n-1],16)
+    }
+    if err == nil {
+      switch {
+      case n==4 && os.Args[1]=="trace":
+        fmt.Print(aes_trace_text(m,k))
+      case n==4 && os.Args[1]=="grid":
+        fmt.Print(aes_trace_grid(m,k))
+      case n==7 && os.Args[1]=="fault":
+        var f fault
+        var mask []byte
+        f.round,err = strconv.Atoi(os.Args[2])
+        if err == nil {
+          if f.position,err = strconv.Atoi(os.Args[3]); err == nil {
+            mask,err = parse_hex(os.Args[4],1)
+          }
+        }
+        if err == nil && (f.round<1 || f.round>10 || f.position<0 || f.position>15) {
+          err = errors.New("fault: round must be 1 to 10 and position 0 to 15")
+        }
+        if err == nil {
+          f.mask = mask[0]
+          c := encrypt(m,expand_key(k))
+          c_faulty,_ := encrypt_faulty(m,k,[]fault{f},false)
+          _,detected := encrypt_faulty(m,k,[]fault{f},true)
+          pretty("Correct", c[0:])
+          pretty("Faulty", c_faulty[0:])
+          pretty("Difference", xor(c[0:],c_faulty[0:]))
+          fmt.Printf("With the countermeasure: %v\r\n", detected)
+        }
+      default:
+        err = errors.New("unknown command, see the comment above main() for usage")
+      }
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    return
+  }
+
   println("Test AES crypto");
 
   key := to_bytes("12345612345612345612345612345612")
   msg := to_bytes("abcdefabcdefabcdefabcdefabcdefab")
   
-  crypt := encrypt(msg,key)
-  clear := decrypt(crypt[0:],key)
+  keys := expand_key(key)
+  crypt := encrypt(msg,keys)
+  clear := decrypt(crypt[0:],keys)
   
   pretty("Key", key)
   pretty("Message", msg)
   pretty("Encrypted", crypt[0:])
   pretty("Decrypted", clear[0:])
+
+  println("\r\nTest AES-192 and AES-256")
+  fips_msg := to_bytes("00112233445566778899aabbccddeeff") // FIPS-197 Appendix C
+  keys,_ = key_schedule(to_bytes("000102030405060708090a0b0c0d0e0f1011121314151617"),0,24)
+  crypt = encrypt(fips_msg,keys)
+  pretty("192 bit key (should be DD-A9-7C-A4-86-4C-DF-E0-6E-AF-70-A0-EC-0D-71-91)", crypt[0:])
+  clear = decrypt(crypt[0:],keys)
+  pretty("Decrypted (should be 00-11-22-33-44-55-66-77-88-99-AA-BB-CC-DD-EE-FF)", clear[0:])
+  keys,_ = key_schedule(to_bytes("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"),0,32)
+  crypt = encrypt(fips_msg,keys)
+  pretty("256 bit key (should be 8E-A2-B7-CA-51-67-45-BF-EA-FC-49-90-4B-49-60-89)", crypt[0:])
+  clear = decrypt(crypt[0:],keys)
+  pretty("Decrypted (should be 00-11-22-33-44-55-66-77-88-99-AA-BB-CC-DD-EE-FF)", clear[0:])
+
+  println("\r\nTest key whitening")
+  pre := to_bytes("000102030405060708090a0b0c0d0e0f")
+  post := to_bytes("f0e0d0c0b0a090807060504030201000")
+  keys = expand_key(key)
+  whitened := whiten(aes_encrypter(keys),pre,post)(msg)
+  pretty("Whitened AES", whitened)
+  pretty("Unwhitened (should be the message)", unwhiten(aes_decrypter(keys),pre,post)(whitened))
+
+  println("\r\nTest AES-CMAC")
+  cmac_key := to_bytes("2b7e151628aed2a6abf7158809cf4f3c")
+  mac,_ := cmac(nil,cmac_key)
+  pretty("Empty message (should be BB-1D-69-29-E9-59-37-28-7F-A3-7D-12-9B-75-67-46)", mac)
+  mac,_ = cmac(to_bytes("6bc1bee22e409f96e93d7e117393172a"),cmac_key)
+  pretty("One block (should be 07-0A-16-B4-6B-4D-41-44-F7-9B-DD-9D-D0-4A-28-7C)", mac)
+  mac,_ = cmac(nil,to_bytes("603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4"))
+  pretty("Empty message, 256 bit key (should be 02-89-62-F6-1B-7B-F8-9E-FC-6B-55-1F-46-67-D9-83)", mac)
+  _,err := cmac(nil,cmac_key[:15])
+  fmt.Printf("15 byte key (should fail): %v\r\n", err)
+
+  println("\r\nTest key schedule")
+  fips_keys := []string{ // FIPS-197 Appendix A
+    "2b7e151628aed2a6abf7158809cf4f3c",
+    "8e73b0f7da0e6452c810f32b809079e562f8ead2522c6b7b",
+    "603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4"}
+  fips_last := []string{"D0-14-F9-A8-C9-EE-25-89-E1-3F-0C-C8-B6-63-0C-A6", "E9-8B-A0-6F-44-8C-77-3C-8E-CC-72-04-01-00-22-02",
+    "FE-48-90-D1-E6-18-8D-0B-04-6D-F3-44-70-6C-63-1E"}
+  for i,fips_key := range fips_keys {
+    size := len(fips_key)/2
+    keys,_ := key_schedule(to_bytes(fips_key),0,size)
+    pretty(fmt.Sprintf("%d bit last round key (should be %s)", size*8, fips_last[i]), keys[len(keys)-16:])
+    last := (len(keys)-size)/16 // The last round to start from with enough bytes after it
+    keys,_ = key_schedule(keys[last*16:last*16+size],last,size)
+    pretty(fmt.Sprintf("Key from round %d onwards (should be %X)", last, to_bytes(fips_key)), keys[0:size])
+  }
+
+  println("\r\nTest TR-31 key block (version D)")
+  h := tr31_header{'D',"D0",'A','B',"00",'E'}
+  for _,kbpk := range fips_keys {
+    block,err := tr31_wrap(tr31_d,to_bytes(kbpk),h,msg)
+    if err != nil {
+      fmt.Printf("Wrap failed: %s\r\n", err)
+      return
+    }
+    fmt.Printf("Key block with a %d bit KBPK:\r\n%s\r\n", len(kbpk)*4, block)
+    _,unwrapped,err := tr31_unwrap(tr31_d,to_bytes(kbpk),block)
+    if err != nil {
+      fmt.Printf("Unwrap failed: %s\r\n", err)
+      return
+    }
+    pretty("Unwrapped (should be the message)", unwrapped)
+    _,_,err = tr31_unwrap(tr31_d,to_bytes(kbpk),block[:5]+"K0"+block[7:]) // Try to turn it into a key encryption key
+    fmt.Printf("Tampered header (should fail): %v\r\n", err)
+  }
 }
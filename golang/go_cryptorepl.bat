@@ -0,0 +1,7 @@
+@echo off
+if exist go_cryptorepl.8 del go_cryptorepl.8
+if exist go_cryptorepl.exe del go_cryptorepl.exe
+8g go_cryptorepl.go shared_aes.go shared_des.go shared_rsa.go shared_tr31.go shared_util.go
+if exist go_cryptorepl.8 8l -o go_cryptorepl.exe go_cryptorepl.8
+if exist go_cryptorepl.exe go_cryptorepl.exe
+pause
\ No newline at end of file
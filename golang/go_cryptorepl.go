@@ -0,0 +1,294 @@
+// An interactive terminal REPL for exploring AES, DES and RSA. Type a command like
+//  aes.encrypt key=2b7e151628aed2a6abf7158809cf4f3c msg=3243f6a8885a308d313198a2e0370734
+// and it shows the result, recording every step along the way to go through with next and prev. It can also run a
+// file of commands, for classroom demos. The ciphers are in the shared files, so build this along with them:
+//  go run go_cryptorepl.go shared_*.go
+// Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog - http://github.com/chrishulbert/crypto
+
+package main
+import "fmt"         // For printf
+import "os"          // For the command line, the terminal and script files
+import "strconv"     // For parsing numbers in the RSA commands
+import "math/big"    // For RSA's big numbers
+import "crypto/rand" // For making RSA keys
+import "bufio"       // For reading commands a line at a time
+import "io"          // For reading commands from the terminal or a file
+import "strings"     // For splitting commands up
+
+// The REPL core reads commands like "aes.encrypt key=.. msg=..", hands them to the command handlers below, and steps
+// through what they recorded with next and prev
+// The REPL remembers the steps of the last thing it ran, so they can be stepped through with next and prev
+type repl_state struct {
+  steps []string // Each step, ready to print
+  pos   int      // Which step was shown last, -1 if none yet
+}
+
+// Starts recording a new set of steps
+func (r *repl_state) reset() {
+  r.steps = nil
+  r.pos = -1
+}
+
+// Records a step
+func (r *repl_state) add(step string) {
+  r.steps = append(r.steps, step)
+}
+
+// Shows the step 'delta' away from the last one shown
+func (r *repl_state) move(delta int) {
+  if len(r.steps)==0 {
+    fmt.Printf("Nothing to step through yet\r\n")
+    return
+  }
+  if r.pos+delta<0 || r.pos+delta>=len(r.steps) {
+    fmt.Printf("No more steps that way\r\n")
+    return
+  }
+  r.pos += delta
+  fmt.Printf("Step %d of %d: %s\r\n", r.pos+1, len(r.steps), r.steps[r.pos])
+}
+
+// Splits a command like "aes.encrypt key=00112233 msg=44556677" into its name and arguments
+func parse_command(line string) (name string, args map[string]string) {
+  args = make(map[string]string)
+  fields := strings.Fields(line)
+  for i,f := range fields {
+    if i==0 {
+      name = f
+    } else if eq := strings.Index(f,"="); eq>0 {
+      args[f[:eq]] = f[eq+1:]
+    }
+  }
+  return
+}
+
+// Runs the interactive prompt, reading commands a line at a time until quit or the end of input
+// Input can be the terminal, or a script file for classroom demos, in which case each command is echoed as it runs
+// 'handle' runs the cipher's own commands, returning false for commands it doesn't know
+func repl(in io.Reader, script bool, help string, handle func(r *repl_state, name string, args map[string]string) bool) {
+  r := &repl_state{pos: -1}
+  reader := bufio.NewReader(in)
+  for {
+    if !script {
+      fmt.Print("> ")
+    }
+    line, err := reader.ReadString('\n')
+    line = strings.TrimSpace(line)
+    if line!="" && line[0]!='#' { // Skip blank lines and comments
+      if script {
+        fmt.Printf("> %s\r\n", line)
+      }
+      name, args := parse_command(line)
+      switch name {
+      case "quit", "exit":
+        return
+      case "next":
+        r.move(1)
+      case "prev":
+        r.move(-1)
+      case "help":
+        fmt.Print(help + "next - show the next step of the last command\r\nprev - show the previous step\r\nquit - leave\r\n")
+      default:
+        if !handle(r, name, args) {
+          fmt.Printf("Unknown command %s, try help\r\n", name)
+        }
+      }
+    }
+    if err != nil { // End of input
+      if !script {
+        fmt.Print("\r\n")
+      }
+      return
+    }
+  }
+}
+
+// Starts the REPL from the command line: with no file it's interactive, otherwise it runs the script in the file
+func start_repl(args []string, help string, handle func(r *repl_state, name string, args map[string]string) bool) {
+  if len(args)==0 {
+    repl(os.Stdin, false, help, handle)
+    return
+  }
+  file, err := os.Open(args[0])
+  if err != nil {
+    fmt.Printf("%s\r\n", err)
+    os.Exit(1)
+  }
+  defer file.Close()
+  repl(file, true, help, handle)
+}
+
+const aes_repl_help = "aes.encrypt key=<16 bytes hex> msg=<16 bytes hex> - encrypt a block, recording every step\r\n" +
+  "aes.decrypt key=<16 bytes hex> msg=<16 bytes hex> - decrypt a block, recording every step\r\n" +
+  "aes.keys key=<16 bytes hex> - show the expanded round keys\r\n"
+
+// Runs the AES commands for the REPL
+func aes_command(r *repl_state, name string, args map[string]string) bool {
+  switch name {
+  case "aes.encrypt", "aes.decrypt", "aes.keys":
+  default:
+    return false
+  }
+  k,err := parse_hex(args["key"],16)
+  if err != nil {
+    fmt.Printf("key: %s\r\n", err)
+    return true
+  }
+  if name=="aes.keys" {
+    keys := expand_key(k)
+    for round:=0; round<=10; round++ {
+      pretty(fmt.Sprintf("Round key %d", round), keys[round*16:round*16+16])
+    }
+    return true
+  }
+  m,err := parse_hex(args["msg"],16)
+  if err != nil {
+    fmt.Printf("msg: %s\r\n", err)
+    return true
+  }
+  r.reset()
+  record := func(round int, step string, state []byte) {
+    r.add(fmt.Sprintf("round[%2d].%s\r\n%s", round, step, state_grid(state)))
+  }
+  var out [16]byte
+  if name=="aes.encrypt" {
+    out = encrypt_traced(m,k,record)
+  } else {
+    out = decrypt_traced(m,k,record)
+  }
+  pretty("Result", out[0:])
+  fmt.Printf("%d steps recorded, use next and prev to step through them\r\n", len(r.steps))
+  return true
+}
+
+const des_repl_help = "des.encrypt key=<8 bytes hex> msg=<8 bytes hex> - encrypt a block, recording every round\r\n" +
+  "des.decrypt key=<8 bytes hex> msg=<8 bytes hex> - decrypt a block, recording every round\r\n" +
+  "des.subkeys key=<8 bytes hex> - show the 16 subkeys\r\n"
+
+// Runs the DES commands for the REPL
+func des_command(r *repl_state, name string, args map[string]string) bool {
+  switch name {
+  case "des.encrypt", "des.decrypt", "des.subkeys":
+  default:
+    return false
+  }
+  k,err := parse_hex(args["key"],8)
+  if err != nil {
+    fmt.Printf("key: %s\r\n", err)
+    return true
+  }
+  subkeys := expand(k)
+  if name=="des.subkeys" {
+    for i:=0;i<16;i++ {
+      pretty(fmt.Sprintf("K%d", i+1), subkeys[i])
+    }
+    return true
+  }
+  m,err := parse_hex(args["msg"],8)
+  if err != nil {
+    fmt.Printf("msg: %s\r\n", err)
+    return true
+  }
+
+  // Decrypting is encrypting with the subkeys in reverse order, so the same tracer can show either
+  if name=="des.decrypt" {
+    reversed := make([][]byte,16)
+    for i:=0;i<16;i++ {
+      reversed[i] = subkeys[15-i]
+    }
+    subkeys = reversed
+  }
+  r.reset()
+  l,rr := split(ip(m))
+  r.add(fmt.Sprintf("IP\r\nL0 = %s\r\nR0 = %s", bits(l,4), bits(rr,4)))
+  out := des_encrypt_rounds(m,subkeys,16,func(t des_trace) {
+    r.add(fmt.Sprintf("Round %d\r\nK = %s\r\nE(R) = %s\r\nK+E(R) = %s\r\nS-boxes = %s\r\nf = %s\r\nL%d = %s\r\nR%d = %s",
+      t.round, bits(t.subkey,6), bits(t.e,6), bits(t.sbox_in,6), bits(t.sbox_out,4), bits(t.f,4), t.round, bits(t.l,4), t.round, bits(t.r,4)))
+  })
+  r.add(fmt.Sprintf("IP-1\r\n%s", bits(out,8)))
+  pretty("Result", out)
+  fmt.Printf("%d steps recorded, use next and prev to step through them\r\n", len(r.steps))
+  return true
+}
+
+const rsa_repl_help = "rsa.keygen bits=<key size, default 512> e=<public exponent, default 65537> - make a new key\r\n" +
+  "rsa.encrypt msg=<hex> - encrypt a message with the key, recording every step of the exponentiation\r\n" +
+  "rsa.decrypt msg=<hex> - decrypt a message with the key, recording every step of the exponentiation\r\n"
+
+// Makes the RSA command handler for the REPL, which remembers the last key made by rsa.keygen
+func rsa_commands() func(r *repl_state, name string, args map[string]string) bool {
+  var priv *PrivateKey
+  return func(r *repl_state, name string, args map[string]string) bool {
+    switch name {
+    case "rsa.keygen":
+      bits, e := 512, DefaultExponent
+      var err error
+      if args["bits"]!="" {
+        if bits, err = strconv.Atoi(args["bits"]); err != nil {
+          fmt.Printf("bits: must be a number\r\n")
+          return true
+        }
+      }
+      if args["e"]!="" {
+        if e, err = strconv.Atoi(args["e"]); err != nil {
+          fmt.Printf("e: must be a number\r\n")
+          return true
+        }
+      }
+      key, err := GenerateKeyWithExponent(rand.Reader, bits, e)
+      if err != nil {
+        fmt.Printf("%s\r\n", err)
+        return true
+      }
+      priv = key
+      fmt.Printf("Prime p:\r\n %x\r\nPrime q:\r\n %x\r\n", priv.P, priv.Q)
+      fmt.Printf("Public key (n):\r\n %x\r\nExponent (e):\r\n %x\r\nSecret key (d):\r\n %x\r\n", priv.N, priv.E, priv.D)
+    case "rsa.encrypt", "rsa.decrypt":
+      if priv == nil {
+        fmt.Printf("No key yet, use rsa.keygen first\r\n")
+        return true
+      }
+      m, ok := new(big.Int).SetString(args["msg"], 16)
+      if !ok || m.Cmp(priv.N)>=0 {
+        fmt.Printf("msg: must be hex, and less than n\r\n")
+        return true
+      }
+      exp := big.NewInt(int64(priv.E))
+      if name=="rsa.decrypt" {
+        exp = priv.D
+      }
+      r.reset()
+      result := mod_exp_traced(m, exp, priv.N, func(bit int, set bool, result *big.Int) {
+        op := "square"
+        if set {
+          op = "square and multiply"
+        }
+        r.add(fmt.Sprintf("Bit %d of the exponent is %d: %s\r\n %x", bit, exp.Bit(bit), op, result))
+      })
+      fmt.Printf("Result:\r\n %x\r\n", result)
+      fmt.Printf("%d steps recorded, use next and prev to step through them\r\n", len(r.steps))
+    default:
+      return false
+    }
+    return true
+  }
+}
+
+// Runs the REPL:
+//  go_cryptorepl - Explore the ciphers interactively, type help for the commands
+//  go_cryptorepl script.txt - Run a file of commands, one per line, echoing each as it goes. Lines starting with #
+//    are comments, eg:
+//    # FIPS-197 Appendix B
+//    aes.encrypt key=2b7e151628aed2a6abf7158809cf4f3c msg=3243f6a8885a308d313198a2e0370734
+//    next
+func main() {
+  if len(os.Args)>2 {
+    fmt.Printf("unknown command, see the comment above main() for usage\r\n")
+    os.Exit(1)
+  }
+  rsa_command := rsa_commands()
+  start_repl(os.Args[1:], aes_repl_help + des_repl_help + rsa_repl_help,
+    func(r *repl_state, name string, args map[string]string) bool {
+      return aes_command(r, name, args) || des_command(r, name, args) || rsa_command(r, name, args)
+    })
+}
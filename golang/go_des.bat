@@ -1,7 +1,7 @@
 @echo off
 if exist go_des.8   del go_des.8
 if exist go_des.exe del go_des.exe
-8g go_des.go
+8g go_des.go shared_aes.go shared_des.go shared_rsa.go shared_tr31.go shared_util.go
 if exist go_des.8   8l -o go_des.exe go_des.8
 if exist go_des.exe go_des.exe
 pause
\ No newline at end of file
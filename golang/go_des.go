@@ -1,264 +1,52 @@
 // Simple, thoroughly commented implementation of DES / Triple DES using Google Go aka Golang
 // Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog - http://github.com/chrishulbert/crypto
+// The cipher itself is in shared_des.go, so build this along with the shared files:
+//  go run go_des.go shared_*.go
 // Reference: http://orlingrabbe.com/des.htm
 
 package main
-import "fmt"
-
-// S-box lookups transformed so you don't have to figure out rows and columns
-var s1 = [...]byte{ 14, 0,  4,  15, 13, 7,  1,  4,  2,  14, 15, 2,  11, 13, 8,  1,  3,  10, 10, 6,  6,  12, 12, 11, 5,  9,  9,  5,  0,  3,  7,  8,  4,  15, 1,  12, 14, 8,  8,  2,  13, 4,  6,  9,  2,  1,  11, 7,  15, 5,  12, 11, 9,  3,  7,  14, 3,  10, 10, 0,  5,  6,  0,  13, };
-var s2 = [...]byte{ 15, 3,  1,  13, 8,  4,  14, 7,  6,  15, 11, 2,  3,  8,  4,  14, 9,  12, 7,  0,  2,  1,  13, 10, 12, 6,  0,  9,  5,  11, 10, 5,  0,  13, 14, 8,  7,  10, 11, 1,  10, 3,  4,  15, 13, 4,  1,  2,  5,  11, 8,  6,  12, 7,  6,  12, 9,  0,  3,  5,  2,  14, 15, 9,  };
-var s3 = [...]byte{ 10, 13, 0,  7,  9,  0,  14, 9,  6,  3,  3,  4,  15, 6,  5,  10, 1,  2,  13, 8,  12, 5,  7,  14, 11, 12, 4,  11, 2,  15, 8,  1,  13, 1,  6,  10, 4,  13, 9,  0,  8,  6,  15, 9,  3,  8,  0,  7,  11, 4,  1,  15, 2,  14, 12, 3,  5,  11, 10, 5,  14, 2,  7,  12, };
-var s4 = [...]byte{ 7,  13, 13, 8,  14, 11, 3,  5,  0,  6,  6,  15, 9,  0,  10, 3,  1,  4,  2,  7,  8,  2,  5,  12, 11, 1,  12, 10, 4,  14, 15, 9,  10, 3,  6,  15, 9,  0,  0,  6,  12, 10, 11, 1,  7,  13, 13, 8,  15, 9,  1,  4,  3,  5,  14, 11, 5,  12, 2,  7,  8,  2,  4,  14, };
-var s5 = [...]byte{ 2,  14, 12, 11, 4,  2,  1,  12, 7,  4,  10, 7,  11, 13, 6,  1,  8,  5,  5,  0,  3,  15, 15, 10, 13, 3,  0,  9,  14, 8,  9,  6,  4,  11, 2,  8,  1,  12, 11, 7,  10, 1,  13, 14, 7,  2,  8,  13, 15, 6,  9,  15, 12, 0,  5,  9,  6,  10, 3,  4,  0,  5,  14, 3,  };
-var s6 = [...]byte{ 12, 10, 1,  15, 10, 4,  15, 2,  9,  7,  2,  12, 6,  9,  8,  5,  0,  6,  13, 1,  3,  13, 4,  14, 14, 0,  7,  11, 5,  3,  11, 8,  9,  4,  14, 3,  15, 2,  5,  12, 2,  9,  8,  5,  12, 15, 3,  10, 7,  11, 0,  14, 4,  1,  10, 7,  1,  6,  13, 0,  11, 8,  6,  13, };
-var s7 = [...]byte{ 4,  13, 11, 0,  2,  11, 14, 7,  15, 4,  0,  9,  8,  1,  13, 10, 3,  14, 12, 3,  9,  5,  7,  12, 5,  2,  10, 15, 6,  8,  1,  6,  1,  6,  4,  11, 11, 13, 13, 8,  12, 1,  3,  4,  7,  10, 14, 7,  10, 9,  15, 5,  6,  0,  8,  15, 0,  14, 5,  2,  9,  3,  2,  12, };
-var s8 = [...]byte{ 13, 1,  2,  15, 8,  13, 4,  8,  6,  10, 15, 3,  11, 7,  1,  4,  10, 12, 9,  5,  3,  6,  14, 11, 5,  0,  0,  14, 12, 9,  7,  2,  7,  2,  11, 1,  4,  14, 1,  7,  9,  4,  12, 10, 14, 8,  2,  13, 0,  15, 6,  12, 10, 9,  13, 0,  15, 3,  3,  5,  5,  6,  8,  11, };
-
-// Does the DES PC1 permutation, taking a 64 bit key and converting it to 56 bits
-func pc1(k []byte) (out []byte) {
-  out = make([]byte, 7)
-  out[0] = (((k[7]>>7)&1)<<7) + (((k[6]>>7)&1)<<6) + (((k[5]>>7)&1)<<5) + (((k[4]>>7)&1)<<4) + (((k[3]>>7)&1)<<3) + (((k[2]>>7)&1)<<2) + (((k[1]>>7)&1)<<1) + (((k[0]>>7)&1)<<0)
-  out[1] = (((k[7]>>6)&1)<<7) + (((k[6]>>6)&1)<<6) + (((k[5]>>6)&1)<<5) + (((k[4]>>6)&1)<<4) + (((k[3]>>6)&1)<<3) + (((k[2]>>6)&1)<<2) + (((k[1]>>6)&1)<<1) + (((k[0]>>6)&1)<<0)
-  out[2] = (((k[7]>>5)&1)<<7) + (((k[6]>>5)&1)<<6) + (((k[5]>>5)&1)<<5) + (((k[4]>>5)&1)<<4) + (((k[3]>>5)&1)<<3) + (((k[2]>>5)&1)<<2) + (((k[1]>>5)&1)<<1) + (((k[0]>>5)&1)<<0)
-  out[3] = (((k[7]>>4)&1)<<7) + (((k[6]>>4)&1)<<6) + (((k[5]>>4)&1)<<5) + (((k[4]>>4)&1)<<4) + (((k[7]>>1)&1)<<3) + (((k[6]>>1)&1)<<2) + (((k[5]>>1)&1)<<1) + (((k[4]>>1)&1)<<0)
-  out[4] = (((k[3]>>1)&1)<<7) + (((k[2]>>1)&1)<<6) + (((k[1]>>1)&1)<<5) + (((k[0]>>1)&1)<<4) + (((k[7]>>2)&1)<<3) + (((k[6]>>2)&1)<<2) + (((k[5]>>2)&1)<<1) + (((k[4]>>2)&1)<<0)
-  out[5] = (((k[3]>>2)&1)<<7) + (((k[2]>>2)&1)<<6) + (((k[1]>>2)&1)<<5) + (((k[0]>>2)&1)<<4) + (((k[7]>>3)&1)<<3) + (((k[6]>>3)&1)<<2) + (((k[5]>>3)&1)<<1) + (((k[4]>>3)&1)<<0)
-  out[6] = (((k[3]>>3)&1)<<7) + (((k[2]>>3)&1)<<6) + (((k[1]>>3)&1)<<5) + (((k[0]>>3)&1)<<4) + (((k[3]>>4)&1)<<3) + (((k[2]>>4)&1)<<2) + (((k[1]>>4)&1)<<1) + (((k[0]>>4)&1)<<0)
-  return
-}
-
-// Does the DES PC2 permutation, taking a 56bit CnDn and returning a 48bit Kn 
-func pc2(in []byte) (out []byte) {
-  out = make([]byte, 6)
-  out[0] = (((in[1]>>2)&1)<<7) + (((in[2]>>7)&1)<<6) + (((in[1]>>5)&1)<<5) + (((in[2]>>0)&1)<<4) + (((in[0]>>7)&1)<<3) + (((in[0]>>3)&1)<<2) + (((in[0]>>5)&1)<<1) + (((in[3]>>4)&1)<<0);
-  out[1] = (((in[1]>>1)&1)<<7) + (((in[0]>>2)&1)<<6) + (((in[2]>>3)&1)<<5) + (((in[1]>>6)&1)<<4) + (((in[2]>>1)&1)<<3) + (((in[2]>>5)&1)<<2) + (((in[1]>>4)&1)<<1) + (((in[0]>>4)&1)<<0);
-  out[2] = (((in[3]>>6)&1)<<7) + (((in[0]>>0)&1)<<6) + (((in[1]>>0)&1)<<5) + (((in[0]>>1)&1)<<4) + (((in[3]>>5)&1)<<3) + (((in[2]>>4)&1)<<2) + (((in[1]>>3)&1)<<1) + (((in[0]>>6)&1)<<0);
-  out[3] = (((in[5]>>7)&1)<<7) + (((in[6]>>4)&1)<<6) + (((in[3]>>1)&1)<<5) + (((in[4]>>3)&1)<<4) + (((in[5]>>1)&1)<<3) + (((in[6]>>1)&1)<<2) + (((in[3]>>2)&1)<<1) + (((in[4]>>0)&1)<<0);
-  out[4] = (((in[6]>>5)&1)<<7) + (((in[5]>>3)&1)<<6) + (((in[4]>>7)&1)<<5) + (((in[5]>>0)&1)<<4) + (((in[5]>>4)&1)<<3) + (((in[6]>>7)&1)<<2) + (((in[4]>>1)&1)<<1) + (((in[6]>>0)&1)<<0);
-  out[5] = (((in[4]>>6)&1)<<7) + (((in[6]>>3)&1)<<6) + (((in[5]>>2)&1)<<5) + (((in[5]>>6)&1)<<4) + (((in[6]>>6)&1)<<3) + (((in[4]>>4)&1)<<2) + (((in[3]>>3)&1)<<1) + (((in[3]>>0)&1)<<0);
-  return
-}
-
-// Does the Initial Permutation on the 64 bits of the message data. Output is also 64 bits.
-func ip(in []byte) (out []byte) {
-  out = make([]byte,8)
-  out[0] = (((in[7]>>6)&1)<<7) + (((in[6]>>6)&1)<<6) + (((in[5]>>6)&1)<<5) + (((in[4]>>6)&1)<<4) + (((in[3]>>6)&1)<<3) + (((in[2]>>6)&1)<<2) + (((in[1]>>6)&1)<<1) + (((in[0]>>6)&1)<<0);
-  out[1] = (((in[7]>>4)&1)<<7) + (((in[6]>>4)&1)<<6) + (((in[5]>>4)&1)<<5) + (((in[4]>>4)&1)<<4) + (((in[3]>>4)&1)<<3) + (((in[2]>>4)&1)<<2) + (((in[1]>>4)&1)<<1) + (((in[0]>>4)&1)<<0);
-  out[2] = (((in[7]>>2)&1)<<7) + (((in[6]>>2)&1)<<6) + (((in[5]>>2)&1)<<5) + (((in[4]>>2)&1)<<4) + (((in[3]>>2)&1)<<3) + (((in[2]>>2)&1)<<2) + (((in[1]>>2)&1)<<1) + (((in[0]>>2)&1)<<0);
-  out[3] = (((in[7]>>0)&1)<<7) + (((in[6]>>0)&1)<<6) + (((in[5]>>0)&1)<<5) + (((in[4]>>0)&1)<<4) + (((in[3]>>0)&1)<<3) + (((in[2]>>0)&1)<<2) + (((in[1]>>0)&1)<<1) + (((in[0]>>0)&1)<<0);
-  out[4] = (((in[7]>>7)&1)<<7) + (((in[6]>>7)&1)<<6) + (((in[5]>>7)&1)<<5) + (((in[4]>>7)&1)<<4) + (((in[3]>>7)&1)<<3) + (((in[2]>>7)&1)<<2) + (((in[1]>>7)&1)<<1) + (((in[0]>>7)&1)<<0);
-  out[5] = (((in[7]>>5)&1)<<7) + (((in[6]>>5)&1)<<6) + (((in[5]>>5)&1)<<5) + (((in[4]>>5)&1)<<4) + (((in[3]>>5)&1)<<3) + (((in[2]>>5)&1)<<2) + (((in[1]>>5)&1)<<1) + (((in[0]>>5)&1)<<0);
-  out[6] = (((in[7]>>3)&1)<<7) + (((in[6]>>3)&1)<<6) + (((in[5]>>3)&1)<<5) + (((in[4]>>3)&1)<<4) + (((in[3]>>3)&1)<<3) + (((in[2]>>3)&1)<<2) + (((in[1]>>3)&1)<<1) + (((in[0]>>3)&1)<<0);
-  out[7] = (((in[7]>>1)&1)<<7) + (((in[6]>>1)&1)<<6) + (((in[5]>>1)&1)<<5) + (((in[4]>>1)&1)<<4) + (((in[3]>>1)&1)<<3) + (((in[2]>>1)&1)<<2) + (((in[1]>>1)&1)<<1) + (((in[0]>>1)&1)<<0);
-  return
-}
-
-// Does the IP-1 after the encryption rounds
-func ip_reverse(in []byte) (out []byte) {
-  out = make([]byte,8)
-  out[0] = (((in[4]>>0)&1)<<7) + (((in[0]>>0)&1)<<6) + (((in[5]>>0)&1)<<5) + (((in[1]>>0)&1)<<4) + (((in[6]>>0)&1)<<3) + (((in[2]>>0)&1)<<2) + (((in[7]>>0)&1)<<1) + (((in[3]>>0)&1)<<0);
-  out[1] = (((in[4]>>1)&1)<<7) + (((in[0]>>1)&1)<<6) + (((in[5]>>1)&1)<<5) + (((in[1]>>1)&1)<<4) + (((in[6]>>1)&1)<<3) + (((in[2]>>1)&1)<<2) + (((in[7]>>1)&1)<<1) + (((in[3]>>1)&1)<<0);
-  out[2] = (((in[4]>>2)&1)<<7) + (((in[0]>>2)&1)<<6) + (((in[5]>>2)&1)<<5) + (((in[1]>>2)&1)<<4) + (((in[6]>>2)&1)<<3) + (((in[2]>>2)&1)<<2) + (((in[7]>>2)&1)<<1) + (((in[3]>>2)&1)<<0);
-  out[3] = (((in[4]>>3)&1)<<7) + (((in[0]>>3)&1)<<6) + (((in[5]>>3)&1)<<5) + (((in[1]>>3)&1)<<4) + (((in[6]>>3)&1)<<3) + (((in[2]>>3)&1)<<2) + (((in[7]>>3)&1)<<1) + (((in[3]>>3)&1)<<0);
-  out[4] = (((in[4]>>4)&1)<<7) + (((in[0]>>4)&1)<<6) + (((in[5]>>4)&1)<<5) + (((in[1]>>4)&1)<<4) + (((in[6]>>4)&1)<<3) + (((in[2]>>4)&1)<<2) + (((in[7]>>4)&1)<<1) + (((in[3]>>4)&1)<<0);
-  out[5] = (((in[4]>>5)&1)<<7) + (((in[0]>>5)&1)<<6) + (((in[5]>>5)&1)<<5) + (((in[1]>>5)&1)<<4) + (((in[6]>>5)&1)<<3) + (((in[2]>>5)&1)<<2) + (((in[7]>>5)&1)<<1) + (((in[3]>>5)&1)<<0);
-  out[6] = (((in[4]>>6)&1)<<7) + (((in[0]>>6)&1)<<6) + (((in[5]>>6)&1)<<5) + (((in[1]>>6)&1)<<4) + (((in[6]>>6)&1)<<3) + (((in[2]>>6)&1)<<2) + (((in[7]>>6)&1)<<1) + (((in[3]>>6)&1)<<0);
-  out[7] = (((in[4]>>7)&1)<<7) + (((in[0]>>7)&1)<<6) + (((in[5]>>7)&1)<<5) + (((in[1]>>7)&1)<<4) + (((in[6]>>7)&1)<<3) + (((in[2]>>7)&1)<<2) + (((in[7]>>7)&1)<<1) + (((in[3]>>7)&1)<<0);
-  return
-}
-
-// Does the 'E' permutation
-// Takes 32 bits in and puts 48 bits out
-func e(in []byte) (out []byte) {
-  out = make ([]byte,6)
-  out[0] = (((in[3]>>0)&1)<<7) + (((in[0]>>7)&1)<<6) + (((in[0]>>6)&1)<<5) + (((in[0]>>5)&1)<<4) + (((in[0]>>4)&1)<<3) + (((in[0]>>3)&1)<<2) + (((in[0]>>4)&1)<<1) + (((in[0]>>3)&1)<<0);
-  out[1] = (((in[0]>>2)&1)<<7) + (((in[0]>>1)&1)<<6) + (((in[0]>>0)&1)<<5) + (((in[1]>>7)&1)<<4) + (((in[0]>>0)&1)<<3) + (((in[1]>>7)&1)<<2) + (((in[1]>>6)&1)<<1) + (((in[1]>>5)&1)<<0);
-  out[2] = (((in[1]>>4)&1)<<7) + (((in[1]>>3)&1)<<6) + (((in[1]>>4)&1)<<5) + (((in[1]>>3)&1)<<4) + (((in[1]>>2)&1)<<3) + (((in[1]>>1)&1)<<2) + (((in[1]>>0)&1)<<1) + (((in[2]>>7)&1)<<0);
-  out[3] = (((in[1]>>0)&1)<<7) + (((in[2]>>7)&1)<<6) + (((in[2]>>6)&1)<<5) + (((in[2]>>5)&1)<<4) + (((in[2]>>4)&1)<<3) + (((in[2]>>3)&1)<<2) + (((in[2]>>4)&1)<<1) + (((in[2]>>3)&1)<<0);
-  out[4] = (((in[2]>>2)&1)<<7) + (((in[2]>>1)&1)<<6) + (((in[2]>>0)&1)<<5) + (((in[3]>>7)&1)<<4) + (((in[2]>>0)&1)<<3) + (((in[3]>>7)&1)<<2) + (((in[3]>>6)&1)<<1) + (((in[3]>>5)&1)<<0);
-  out[5] = (((in[3]>>4)&1)<<7) + (((in[3]>>3)&1)<<6) + (((in[3]>>4)&1)<<5) + (((in[3]>>3)&1)<<4) + (((in[3]>>2)&1)<<3) + (((in[3]>>1)&1)<<2) + (((in[3]>>0)&1)<<1) + (((in[0]>>7)&1)<<0);
-  return
-}
-
-// Does the 'P' permutation
-// 32 bits in, 32 bits out
-func p(in []byte) (out []byte) {
-  out = make ([]byte,4)
-  out[0] = (((in[1]>>0)&1)<<7) + (((in[0]>>1)&1)<<6) + (((in[2]>>4)&1)<<5) + (((in[2]>>3)&1)<<4) + (((in[3]>>3)&1)<<3) + (((in[1]>>4)&1)<<2) + (((in[3]>>4)&1)<<1) + (((in[2]>>7)&1)<<0);
-  out[1] = (((in[0]>>7)&1)<<7) + (((in[1]>>1)&1)<<6) + (((in[2]>>1)&1)<<5) + (((in[3]>>6)&1)<<4) + (((in[0]>>3)&1)<<3) + (((in[2]>>6)&1)<<2) + (((in[3]>>1)&1)<<1) + (((in[1]>>6)&1)<<0);
-  out[2] = (((in[0]>>6)&1)<<7) + (((in[0]>>0)&1)<<6) + (((in[2]>>0)&1)<<5) + (((in[1]>>2)&1)<<4) + (((in[3]>>0)&1)<<3) + (((in[3]>>5)&1)<<2) + (((in[0]>>5)&1)<<1) + (((in[1]>>7)&1)<<0);
-  out[3] = (((in[2]>>5)&1)<<7) + (((in[1]>>3)&1)<<6) + (((in[3]>>2)&1)<<5) + (((in[0]>>2)&1)<<4) + (((in[2]>>2)&1)<<3) + (((in[1]>>5)&1)<<2) + (((in[0]>>4)&1)<<1) + (((in[3]>>7)&1)<<0);
-  return
-}
-
-// Split 6 bytes into 8 * 6 bit pieces
-func split6(in []byte) (out []byte) {
-  // in:  11111111 11111111 11111111 11111111 11111111 11111111
-  // #:     0           1       2        3         4       5
-  // out: 11111122 22223333 33444444 55555566 66667777 77888888    
-  out=make([]byte,8)
-  out[0] = in[0]>>2;
-  out[1] = ((in[0]&3)<<4) + (in[1]>>4);
-  out[2] = ((in[1]&15)<<2) + (in[2]>>6);
-  out[3] = in[2]&63;
-  out[4] = in[3]>>2;
-  out[5] = ((in[3]&3)<<4) + (in[4]>>4);
-  out[6] = ((in[4]&15)<<2) + (in[5]>>6);
-  out[7] = in[5]&63;
-  return
-}
-
-// Xor's 2 arrays
-func xor(a []byte,b []byte) (out []byte) {
-  out = make([]byte,len(a))
-  for i:=0;i<len(a);i++ {
-    out[i] = a[i] ^ b[i]
-  }
-  return
-}
-
-// Takes 8 * 6-bit values, does a s-box lookup which returns 4 bits each,
-// and joins the 8*4 bits to return 4 bytes
-func sbox(in []byte) (s []byte) {
-  s = make([]byte,4)
-  s[0] = (s1[in[0]]<<4) + s2[in[1]]
-  s[1] = (s3[in[2]]<<4) + s4[in[3]]
-  s[2] = (s5[in[4]]<<4) + s6[in[5]]
-  s[3] = (s7[in[6]]<<4) + s8[in[7]]
-  return
-}
-
-// Takes 32 bits input, 48 bits key Kn, gives 32 bits output
-// Does: P(S(Kn ^ E(R))), where R = in = R(n-1)
-func f(in []byte,key []byte) (out []byte) {
-  er := e(in)       // Expand using E to 48 bits
-  x  := xor(er,key) // Now XOR the output of E with the key Kn
-  b  := split6(x)   // Split it into 8 blocks of 6-bits
-  s  := sbox(b)     // Now do the 'S box' lookup and return it to 32 bits
-  return p(s)       // Now do final P permutation
-}
-
-// Given 56 bits, representing 28 bits of C and D, shifts both left by 1 bit
-func left1(in []byte) (out []byte) {
-  out = make([]byte, 7)
-  // C
-  out[0]=(in[0]<<1) + (in[1]>>7)
-  out[1]=(in[1]<<1) + (in[2]>>7)
-  out[2]=(in[2]<<1) + (in[3]>>7)
-  // 1 nibble each C / D
-  out[3]=
-    ((in[3]&0xf0)<<1) + ((in[0]>>7)<<4) + // the C nibble
-    ((in[3]&7)<<1) + (in[4]>>7) // the D nibble
-  // D
-  out[4]=(in[4]<<1) + (in[5]>>7)
-  out[5]=(in[5]<<1) + (in[6]>>7)
-  out[6]=(in[6]<<1) + ((in[3]>>3)&1)
-  return
-}
-
-// Given 56 bits, representing 28 bits of C and D, shifts both left by 2 bits
-func left2(in []byte) (out []byte) {
-  out = make([]byte, 7)
-  // C
-  out[0]=(in[0]<<2) + (in[1]>>6)
-  out[1]=(in[1]<<2) + (in[2]>>6)
-  out[2]=(in[2]<<2) + (in[3]>>6)
-  // 1 nibble each C / D
-  out[3]=
-    ((in[3]&0xf0)<<2) + ((in[0]>>6)<<4) + // the C nibble
-    ((in[3]&3)<<2) + (in[4]>>6) // the D nibble
-  // D
-  out[4]=(in[4]<<2) + (in[5]>>6)
-  out[5]=(in[5]<<2) + (in[6]>>6)
-  out[6]=(in[6]<<2) + ((in[3]>>2)&3)
-  return
-}
-
-// Expands a 64-bit key into 16 * 48 bit subkeys
-func expand(key []byte) (keys [][]byte) {
-  // Get the 56-bit PC1 permutation
-  kplus := pc1(key)
-  
-  // Do the left shifts
-  keys = make([][]byte,16)
-  keys[0] =  left1(kplus) // Iteration 1
-  keys[1] =  left1(keys[0])
-  keys[2] =  left2(keys[1])
-  keys[3] =  left2(keys[2])
-  keys[4] =  left2(keys[3])
-  keys[5] =  left2(keys[4])
-  keys[6] =  left2(keys[5])
-  keys[7] =  left2(keys[6])
-  keys[8] =  left1(keys[7])
-  keys[9] =  left2(keys[8])
-  keys[10] = left2(keys[9])
-  keys[11] = left2(keys[10])
-  keys[12] = left2(keys[11])
-  keys[13] = left2(keys[12])
-  keys[14] = left2(keys[13])
-  keys[15] = left1(keys[14])
-  
-  // Apply the PC2 perm to each key
+import "fmt"           // For printf
+import "errors"        // For the errors from crypt(3) and the attacks
+import "crypto/subtle" // For comparing hashes and ciphertexts in constant time
+import "strings"       // For looking up crypt(3)'s base64 characters
+import "os"            // For the command line tools
+import "bufio"         // For writing the meet-in-the-middle table files
+import "runtime"       // For the number of CPUs to search keys with
+import "sync"          // For waiting for the key search goroutines
+import "time"          // For measuring key search speed
+import "encoding/binary" // For the meet-in-the-middle table files
+
+// Renders a full DES encryption as a table, laid out like "The DES Algorithm Illustrated" (see the reference at the top),
+// so that running it with key 133457799BBCDFF1 and message 0123456789ABCDEF can be checked line by line against it
+func des_trace_table(m []byte, key []byte) (s string) {
+  subkeys := expand(key)
+  s += fmt.Sprintf("K = %s\r\n", bits(key,8))
+  s += fmt.Sprintf("K+ = %s\r\n", bits(pc1(key),7))
   for i:=0;i<16;i++ {
-    keys[i] = pc2(keys[i])
+    s += fmt.Sprintf("K%d = %s\r\n", i+1, bits(subkeys[i],6))
   }
-  
-  return
-}
-
-// Splits an array in two halves
-func split(in []byte) (a []byte, b []byte) {
-  l := len(in)/2
-  a = make([]byte,l)
-  b = make([]byte,l)
-  copy (a,in[0:l])
-  copy (b,in[l:])
-  return
-}
-
-// Execute a DES round of encryption, eg:
-// L1 = R0
-// R1 = L0 + f(R0,K1)
-func round(l_in []byte, r_in[] byte, subkey[] byte) (l_out[]byte, r_out[]byte) {
-  l_out = r_in
-  r_out = xor(l_in, f(r_in, subkey))
+  s += fmt.Sprintf("\r\nM = %s\r\n", bits(m,4))
+  s += fmt.Sprintf("IP = %s\r\n", bits(ip(m),4))
+  l,r := split(ip(m))
+  s += fmt.Sprintf("L0 = %s\r\n", bits(l,4))
+  s += fmt.Sprintf("R0 = %s\r\n", bits(r,4))
+  out := des_encrypt_rounds(m,subkeys,16,func(t des_trace) {
+    n := t.round
+    s += fmt.Sprintf("\r\nRound %d\r\n", n)
+    s += fmt.Sprintf("E(R%d) = %s\r\n", n-1, bits(t.e,6))
+    s += fmt.Sprintf("K%d+E(R%d) = %s\r\n", n, n-1, bits(t.sbox_in,6))
+    s += fmt.Sprintf("S1(B1)S2(B2)S3(B3)S4(B4)S5(B5)S6(B6)S7(B7)S8(B8) = %s\r\n", bits(t.sbox_out,4))
+    s += fmt.Sprintf("f = P(S1(B1)S2(B2)...S8(B8)) = %s\r\n", bits(t.f,4))
+    s += fmt.Sprintf("L%d = R%d = %s\r\n", n, n-1, bits(t.l,4))
+    s += fmt.Sprintf("R%d = L%d + f(R%d,K%d) = %s\r\n", n, n-1, n-1, n, bits(t.r,4))
+    l,r = t.l,t.r
+  })
+  s += fmt.Sprintf("\r\nR16L16 = %s\r\n", bits(join(r,l),8))
+  s += fmt.Sprintf("IP-1 = %s\r\n", bits(out,8))
+  s += fmt.Sprintf("C = %X\r\n", out)
   return
 }
 
-// Joins two arrays
-func join(a []byte, b []byte) (out []byte) {
-  out = make([]byte,len(a)+len(b))
-  copy(out,a)
-  copy(out[len(a):],b)
-  return
-}
-
-// Takes a 64-bit message and subkeys
-// Outputs 64 bits to out
-func des_encrypt(m []byte,subkeys [][]byte) (out []byte) {
-  i := ip(m)      // Perform the IP transform
-  l,r := split(i) // Split the result into left and right sides
-  for rnd:=0;rnd<=15;rnd++ {      // Iterate the rounds
-    l,r = round(l,r,subkeys[rnd]) // Perform l=r, r=l^f(r,subkey)
-  }
-  rl := join(r,l)       // Rejoin, but reverse, the sides
-  return ip_reverse(rl) // Perform the IP-1 transform
-}
-
-// Takes a 64-bit message and subkeys
-// Outputs 64 bits to out
-// This is exactly the same as des_encrypt but the subkeys are reversed
-func des_decrypt(m []byte,subkeys [][]byte) (out []byte) {
-  i := ip(m)      // Perform the IP transform
-  l,r := split(i) // Split the result into left and right sides
-  for rnd:=15;rnd>=0;rnd-- {      // Iterate the rounds in reverse for decrypting
-    l,r = round(l,r,subkeys[rnd]) // Perform l=r, r=l^f(r,subkey)
-  }
-  rl := join(r,l)       // Rejoin, but reverse, the sides
-  return ip_reverse(rl) // Perform the IP-1 transform
-}
-
 // Takes a 64 bit message and a 128 bit key, and triple des encrypts it
 func tripledes_encrypt(m []byte,key []byte) (out []byte) {
   a,b := split(key)         // Split the 128 bit key into two DES keys
@@ -281,30 +69,1305 @@ func tripledes_decrypt(m []byte,key []byte) (out []byte) {
   return
 }
 
-// Convert a string eg 85E5A3D7356A61E29A8AFA559AD67102 into an array of bytes
-func to_bytes(s string) []byte {
-  l := len(s)/2
-  b := make([]byte,l)
-  for i:=0;i<l;i++ {
-    fmt.Sscanf(s[i*2:i*2+2],"%x", &b[i])
+// Takes a 64 bit message and a 128 bit key, and double des encrypts it: DES with the first key, then the second.
+// This looks like it should be as strong as a 112 bit key, but the meet-in-the-middle attack (see mitm_attack) breaks
+// it with about as much work as a single DES key. That's why triple DES is used instead
+func doubledes_encrypt(m []byte,key []byte) (out []byte) {
+  a,b := split(key)
+  out = des_encrypt(m,expand(a))   // Encrypt with the first key
+  out = des_encrypt(out,expand(b)) // Then the second
+  return
+}
+
+// Takes a 64 bit message and a 128 bit key, and double des decrypts it
+func doubledes_decrypt(m []byte,key []byte) (out []byte) {
+  a,b := split(key)
+  out = des_decrypt(m,expand(b))   // Decrypt with the second key
+  out = des_decrypt(out,expand(a)) // Then the first
+  return
+}
+
+// Triple DES in CBC mode: each block is xor'd with the previous ciphertext block (or the IV) before encrypting
+// The message must be a multiple of 8 bytes, and the key 16 bytes
+func tdes_cbc_encrypt(m []byte, key []byte, iv []byte) (out []byte, err error) {
+  if len(key)!=16 {
+    return nil, errors.New("tdes: key must be 16 bytes")
+  }
+  prev := iv
+  for i:=0;i<len(m);i+=8 {
+    prev = tripledes_encrypt(xor(m[i:i+8],prev),key) // Encrypt (block ^ previous)
+    out = join(out,prev)
+  }
+  return
+}
+
+// Reverses tdes_cbc_encrypt: decrypt each block, then xor with the previous ciphertext block (or the IV)
+func tdes_cbc_decrypt(c []byte, key []byte, iv []byte) (out []byte, err error) {
+  if len(key)!=16 {
+    return nil, errors.New("tdes: key must be 16 bytes")
+  }
+  prev := iv
+  for i:=0;i<len(c);i+=8 {
+    out = join(out,xor(tripledes_decrypt(c[i:i+8],key),prev))
+    prev = c[i:i+8]
+  }
+  return
+}
+
+// CMAC (NIST SP 800-38B) using two-key triple DES (a 16 byte key), giving an 8 byte MAC
+// This is CBC-MAC with a twist: the last block is xor'd with a subkey so that messages can't be extended
+func tdes_cmac(m []byte, key []byte) ([]byte, error) {
+  if len(key)!=16 {
+    return nil, errors.New("tdes: key must be 16 bytes")
+  }
+  k1 := cmac_double(tripledes_encrypt(make([]byte,8),key), 0x1B) // The subkeys come from encrypting zeros
+  k2 := cmac_double(k1, 0x1B)
+
+  // A complete last block gets xor'd with K1, an incomplete (or empty) one gets padded with 80 00 00.. and xor'd with K2
+  n := (len(m)+7)/8 // Number of blocks
+  if n==0 {
+    n=1
+  }
+  var last []byte
+  if len(m)>0 && len(m)%8==0 {
+    last = xor(m[len(m)-8:],k1)
+  } else {
+    last = make([]byte,8)
+    copy(last,m[(n-1)*8:])
+    last[len(m)-(n-1)*8] = 0x80
+    last = xor(last,k2)
+  }
+
+  // Now it's just CBC encryption with a zero IV, keeping only the final block
+  mac := make([]byte,8)
+  for i:=0;i<n-1;i++ {
+    mac = tripledes_encrypt(xor(mac,m[i*8:i*8+8]),key)
+  }
+  return tripledes_encrypt(xor(mac,last),key), nil
+}
+
+// DESX is DES with key whitening, using a 192 bit key: 64 bits of DES key, 64 bits pre-whitening, 64 bits post-whitening
+// It costs almost nothing extra, but gets DES's effective key strength past brute-forcing 56 bits
+// Takes a 64 bit message and a 192 bit key
+func desx_encrypt(m []byte, key []byte) []byte {
+  subkeys := expand(key[0:8])
+  des := func(block []byte) []byte {
+    return des_encrypt(block,subkeys)
+  }
+  return whiten(des,key[8:16],key[16:24])(m)
+}
+
+// Takes a 64 bit ciphertext and a 192 bit key, and DESX decrypts it
+func desx_decrypt(c []byte, key []byte) []byte {
+  subkeys := expand(key[0:8])
+  des := func(block []byte) []byte {
+    return des_decrypt(block,subkeys)
+  }
+  return unwhiten(des,key[8:16],key[16:24])(c)
+}
+
+// TR-31 version 'B' key blocks are protected by two-key triple DES. The rest is in shared_tr31.go
+var tr31_b = tr31_version{'B', 8, tr31_derive_b, tdes_cmac, tdes_cbc_encrypt, tdes_cbc_decrypt}
+
+// Derives the key block encryption key (KBEK) and MAC key (KBMK) from a two-key triple DES KBPK
+// This uses CMAC as the pseudo-random function in a counter mode KDF (NIST SP 800-108), with 8 bytes of derivation data:
+// counter | key usage (0000 encryption, 0001 MAC) | 00 separator | algorithm (0000 two-key TDES) | key length in bits (0080)
+// Each CMAC only gives 8 bytes, so it's done with counter 1 and 2 and the results are joined to make 16 byte keys
+func tr31_derive_b(kbpk []byte) (kbek []byte, kbmk []byte, err error) {
+  if len(kbpk)!=16 {
+    return nil, nil, errors.New("tr31: version B KBPK must be 16 bytes")
+  }
+  for counter:=byte(1);counter<=2;counter++ {
+    var e,m []byte
+    if e,err = tdes_cmac([]byte{counter,0,0,0,0,0,0,0x80},kbpk); err != nil {
+      return
+    }
+    if m,err = tdes_cmac([]byte{counter,0,1,0,0,0,0,0x80},kbpk); err != nil {
+      return
+    }
+    kbek,kbmk = join(kbek,e),join(kbmk,m)
+  }
+  return
+}
+
+// Unix crypt(3) password hashing is DES with a twist: a salt swaps some of the bits coming out of the E expansion,
+// so that the hashes can't be looked up in a precomputed table, and can't be sped up with off the shelf DES hardware.
+// The traditional form is 2 salt characters + 11 hash characters, eg "abJnggxhB/yWI"
+// The BSDi extended form is "_" + 4 characters of iteration count + 4 of salt + 11 of hash, eg "_J9..CCCCXBrJUJV154M"
+// Reference: http://man.freebsd.org/crypt/3
+// These are the characters crypt uses for its base64, in order of the 6-bit value they represent
+const crypt_alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Decodes a run of crypt base64 characters into a number, the first character being the least significant 6 bits
+func crypt_decode_number(s string) (n uint32, err error) {
+  for i:=0;i<len(s);i++ {
+    v := strings.IndexByte(crypt_alphabet,s[i])
+    if v<0 {
+      return 0, errors.New("crypt: invalid character in salt or count")
+    }
+    n |= uint32(v)<<(6*uint(i))
+  }
+  return
+}
+
+// Encodes a number as crypt base64 characters, least significant 6 bits first
+func crypt_encode_number(n uint32, chars int) (s string) {
+  for i:=0;i<chars;i++ {
+    s += string(crypt_alphabet[(n>>(6*uint(i)))&63])
+  }
+  return
+}
+
+// Encodes the 64 bit DES output as 11 characters, most significant bits first, with 2 zero bits of padding on the end
+func crypt_encode_hash(block []byte) (s string) {
+  padded := join(block,[]byte{0}) // 72 bits, of which we use 66
+  for i:=0;i<11;i++ {
+    bit := i*6 // Which bit this character starts at
+    v := (int(padded[bit/8])<<8 + int(padded[bit/8+1])) >> uint(10-bit%8) // Grab 16 bits so the 6 can straddle bytes
+    s += string(crypt_alphabet[v&63])
+  }
+  return
+}
+
+// Turns a salt into a mask for the 24 bits on each side of the E expansion
+// Salt bit 0 swaps E bits 0 and 24, salt bit 1 swaps E bits 1 and 25, and so on
+func crypt_salt_mask(salt uint32) (mask uint32) {
+  for i:=uint(0);i<24;i++ {
+    if salt&(1<<i) != 0 {
+      mask |= 0x800000>>i
+    }
+  }
+  return
+}
+
+// The 'E' permutation, with bits swapped between the left and right 24 bits wherever the salt mask says to
+func e_salted(in []byte, mask uint32) (out []byte) {
+  out = e(in)
+  l := uint32(out[0])<<16 + uint32(out[1])<<8 + uint32(out[2]) // Left 24 bits
+  r := uint32(out[3])<<16 + uint32(out[4])<<8 + uint32(out[5]) // Right 24 bits
+  swap := (l^r) & mask // The bits that differ and are to be swapped: flipping them on both sides swaps them
+  l ^= swap
+  r ^= swap
+  out[0],out[1],out[2] = byte(l>>16),byte(l>>8),byte(l)
+  out[3],out[4],out[5] = byte(r>>16),byte(r>>8),byte(r)
+  return
+}
+
+// DES encryption as in des_encrypt, but using the salted E permutation in each round's f function
+func des_encrypt_salted(m []byte, subkeys [][]byte, mask uint32) []byte {
+  l,r := split(ip(m))
+  for rnd:=0;rnd<=15;rnd++ {
+    l,r = r,xor(l,p(sbox(split6(xor(e_salted(r,mask),subkeys[rnd]))))) // l=r, r=l^P(S(E'(r)^subkey))
+  }
+  return ip_reverse(join(r,l))
+}
+
+// The DES key comes from the first 8 characters of the password, each shifted left so the unused top bit
+// lands on the parity bit that DES ignores. Shorter passwords are padded with zeros
+func crypt_key(password string) (key []byte) {
+  key = make([]byte,8)
+  for i:=0;i<8 && i<len(password);i++ {
+    key[i] = password[i]<<1
+  }
+  return
+}
+
+// Traditional crypt(3): only the first 8 characters of the password count, and the 12 bit salt is 2 characters
+// The hash is a block of zeros encrypted 25 times using the password as the key
+func unix_crypt(password string, salt string) (string, error) {
+  if len(salt)<2 {
+    return "", errors.New("crypt: salt must be 2 characters")
+  }
+  saltbits,err := crypt_decode_number(salt[0:2])
+  if err != nil {
+    return "", err
+  }
+  subkeys := expand(crypt_key(password))
+  mask := crypt_salt_mask(saltbits)
+  block := make([]byte,8)
+  for i:=0;i<25;i++ {
+    block = des_encrypt_salted(block,subkeys,mask)
+  }
+  return salt[0:2] + crypt_encode_hash(block), nil
+}
+
+// BSDi extended crypt: the whole password counts, the salt is 24 bits, and the iteration count is configurable
+// The setting is "_" + 4 characters of count + 4 characters of salt (any hash after that is ignored)
+func bsdi_crypt(password string, setting string) (string, error) {
+  if len(setting)<9 || setting[0]!='_' {
+    return "", errors.New("crypt: extended setting must be _ + 4 count + 4 salt characters")
+  }
+  count,err := crypt_decode_number(setting[1:5])
+  if err != nil {
+    return "", err
+  }
+  if count==0 {
+    return "", errors.New("crypt: iteration count must not be zero")
+  }
+  saltbits,err := crypt_decode_number(setting[5:9])
+  if err != nil {
+    return "", err
+  }
+
+  // Fold in passwords longer than 8 characters: encrypt the key with itself, then xor in the next 8 characters
+  key := crypt_key(password)
+  for rest:=password;len(rest)>8; {
+    rest = rest[8:]
+    key = xor(des_encrypt(key,expand(key)),crypt_key(rest)) // crypt_key's zero padding leaves the rest of the key alone
+  }
+
+  subkeys := expand(key)
+  mask := crypt_salt_mask(saltbits)
+  block := make([]byte,8)
+  for i:=uint32(0);i<count;i++ {
+    block = des_encrypt_salted(block,subkeys,mask)
+  }
+  return setting[0:9] + crypt_encode_hash(block), nil
+}
+
+// Checks a password against a traditional or extended crypt(3) hash
+func crypt_verify(password string, hash string) bool {
+  var computed string
+  var err error
+  if strings.HasPrefix(hash,"_") {
+    computed,err = bsdi_crypt(password,hash)
+  } else {
+    computed,err = unix_crypt(password,hash)
+  }
+  return err==nil && subtle.ConstantTimeCompare([]byte(computed),[]byte(hash))==1
+}
+
+// LAN Manager hashes are how old Windows versions stored passwords, and are a lesson in what not to do:
+// the password is uppercased, cut to 14 characters, and each 7 character half is used as a DES key to encrypt
+// the constant "KGS!@#$%". There's no salt, and the halves can be cracked separately.
+// Reference: http://en.wikipedia.org/wiki/LM_hash
+// Spreads 7 bytes (56 bits) across the 8 byte DES key, 7 bits in the top of each byte, leaving the parity bits zero
+func lm_key(in []byte) (key []byte) {
+  var bits uint64
+  for i:=0;i<7;i++ {
+    bits = bits<<8 + uint64(in[i])
+  }
+  key = make([]byte,8)
+  for i:=uint(0);i<8;i++ {
+    key[i] = byte(bits>>(49-7*i))<<1
+  }
+  return
+}
+
+// Hashes one 7 byte half of the password
+func lm_half(half []byte) []byte {
+  return des_encrypt([]byte("KGS!@#$%"),expand(lm_key(half)))
+}
+
+// Uppercases the password and pads or truncates it to 14 bytes
+// This only uppercases ASCII: real Windows used the OEM code page
+func lm_password(password string) (pw []byte) {
+  pw = make([]byte,14)
+  copy(pw,strings.ToUpper(password))
+  return
+}
+
+// Computes the 16 byte LM hash of a password
+func lm_hash(password string) []byte {
+  pw := lm_password(password)
+  return join(lm_half(pw[0:7]),lm_half(pw[7:14]))
+}
+
+// Audits a local file of LM hashes against a local wordlist, printing any passwords found
+// Each line of the hash file can be just the hash, user:hash, or pwdump's user:rid:lm:nt:::
+// Because the two halves are hashed separately, we only ever need to crack 7 character halves, and halves from
+// different words combine to find passwords that aren't in the wordlist at all
+func lm_audit(wordlist_file string, hash_file string) error {
+  words,err := os.ReadFile(wordlist_file)
+  if err != nil {
+    return err
+  }
+  hashes,err := os.ReadFile(hash_file)
+  if err != nil {
+    return err
+  }
+
+  // Hash each 7 character half of every word, plus the empty half that every short password ends with
+  halves := make(map[string]string)
+  halves[fmt.Sprintf("%X",lm_half(make([]byte,7)))] = ""
+  for _,word := range strings.Split(string(words),"\n") {
+    word = strings.TrimRight(word,"\r")
+    if word=="" {
+      continue
+    }
+    pw := lm_password(word)
+    halves[fmt.Sprintf("%X",lm_half(pw[0:7]))] = strings.TrimRight(string(pw[0:7]),"\x00")
+    halves[fmt.Sprintf("%X",lm_half(pw[7:14]))] = strings.TrimRight(string(pw[7:14]),"\x00")
+  }
+
+  // Look up both halves of each hash
+  found := 0
+  total := 0
+  for _,line := range strings.Split(string(hashes),"\n") {
+    line = strings.TrimSpace(line)
+    if line=="" {
+      continue
+    }
+    user,hash := "",line
+    fields := strings.Split(line,":")
+    if len(fields)==2 {
+      user,hash = fields[0],fields[1]
+    } else if len(fields)>=4 {
+      user,hash = fields[0],fields[2]
+    }
+    hash = strings.ToUpper(hash)
+    if len(hash)!=32 {
+      fmt.Printf("Skipping bad line: %s\r\n", line)
+      continue
+    }
+    total++
+    first,ok1 := halves[hash[0:16]]
+    second,ok2 := halves[hash[16:32]]
+    switch {
+    case ok1 && ok2 && first+second=="":
+      found++
+      fmt.Printf("%s %s: (empty password)\r\n", hash, user)
+    case ok1 && ok2:
+      found++
+      fmt.Printf("%s %s: %s\r\n", hash, user, first+second)
+    case ok1:
+      fmt.Printf("%s %s: first half only: %s???????\r\n", hash, user, first)
+    case ok2:
+      fmt.Printf("%s %s: second half only: ???????%s\r\n", hash, user, second)
+    }
+  }
+  fmt.Printf("Found %d of %d passwords (uppercase only - LM can't tell what case they were)\r\n", found, total)
+  return nil
+}
+
+// Differential cryptanalysis (Biham & Shamir, 1990) looks at how a difference (xor) between two plaintexts travels
+// through the cipher. The xor with the subkey cancels out of a difference, so only the S-boxes change it, and some
+// input differences give some output differences far more often than they should. Following the likely ones through
+// the rounds lets us guess the difference going into the last round, and then test subkey guesses against it.
+// Full 16 round DES needs 2^47 chosen plaintexts, but cut down to 4 or 6 rounds it falls over in a blink.
+// Reference: http://en.wikipedia.org/wiki/Differential_cryptanalysis
+// All 8 S-boxes, so they can be looped through
+var sboxes = [8][]byte{s1[0:],s2[0:],s3[0:],s4[0:],s5[0:],s6[0:],s7[0:],s8[0:]}
+
+// Gets bit i of an array, counting from the top bit of the first byte
+func get_bit(in []byte, i int) byte {
+  return (in[i/8]>>uint(7-i%8))&1
+}
+
+// Sets bit i of an array to 1
+func set_bit(in []byte, i int) {
+  in[i/8] |= 1<<uint(7-i%8)
+}
+
+// Reverses the 'P' permutation. Worked out by sending each bit through p to see where it lands
+func p_inverse(in []byte) (out []byte) {
+  out = make([]byte,4)
+  for i:=0;i<32;i++ {
+    probe := make([]byte,4)
+    set_bit(probe,i)
+    landed := p(probe)
+    for j:=0;j<32;j++ {
+      if get_bit(landed,j)==1 && get_bit(in,j)==1 {
+        set_bit(out,i)
+      }
+    }
+  }
+  return
+}
+
+// The difference distribution table of an S-box: entry [dx][dy] counts how many of the 64 inputs x
+// give S(x) ^ S(x^dx) = dy. A perfect S-box would have 4 in every entry, but DES's have some as high as 16
+func difference_table(s []byte) (t [64][16]int) {
+  for dx:=0;dx<64;dx++ {
+    for x:=0;x<64;x++ {
+      t[dx][s[x]^s[x^dx]]++
+    }
+  }
+  return
+}
+
+// Shows an S-box's difference distribution table, one row per input difference
+func difference_table_text(n int) (s string) {
+  t := difference_table(sboxes[n-1])
+  s = fmt.Sprintf("S%d difference distribution table (rows: input xor, columns: output xor)\r\n    ", n)
+  for dy:=0;dy<16;dy++ {
+    s += fmt.Sprintf(" %2X", dy)
+  }
+  s += "\r\n"
+  for dx:=0;dx<64;dx++ {
+    s += fmt.Sprintf("%02X: ", dx)
+    for dy:=0;dy<16;dy++ {
+      s += fmt.Sprintf(" %2d", t[dx][dy])
+    }
+    s += "\r\n"
+  }
+  return
+}
+
+// A characteristic: a difference going into the first round (L0'R0', after IP), the difference it
+// should turn into after 3 rounds (L3'R3'), and the probability of that happening
+type characteristic struct {
+  in          []byte
+  out         []byte
+  probability float64
+}
+
+// Finds the best 3 round characteristics of the shape Biham & Shamir used against 6 round DES:
+// If R' only touches one S-box, and that S-box gives output difference dy, then f' = P(dy). Picking L' = f' means
+// after round 1, R1' = L0' ^ f' = 0. A zero difference into round 2 always gives zero out, and round 3 is round 1 again.
+// So (f', R') becomes (R', f') with the S-box's probability squared. They come out best first
+func find_characteristics() (chars []characteristic) {
+  // Try every R' with 1 or 2 bits set, keeping those that only reach one S-box through E
+  for b1:=0;b1<32;b1++ {
+    for b2:=b1;b2<32;b2++ {
+      r := make([]byte,4)
+      set_bit(r,b1)
+      set_bit(r,b2)
+      groups := split6(e(r))
+      active := -1
+      for j:=0;j<8;j++ {
+        if groups[j]!=0 {
+          if active>=0 {
+            active = 99 // More than one S-box
+          } else {
+            active = j
+          }
+        }
+      }
+      if active<0 || active==99 {
+        continue
+      }
+      t := difference_table(sboxes[active])
+      for dy:=1;dy<16;dy++ {
+        if t[groups[active]][dy]==0 {
+          continue
+        }
+        s_out := make([]byte,4) // Put dy in the active S-box's nibble of the S-box outputs
+        s_out[active/2] = byte(dy)<<uint(4*(1-active%2))
+        f := p(s_out)
+        prob := float64(t[groups[active]][dy])/64
+        chars = append(chars, characteristic{join(f,r), join(r,f), prob*prob})
+      }
+    }
+  }
+  // Sort them best first (a simple insertion sort is plenty for a few hundred)
+  for i:=1;i<len(chars);i++ {
+    for j:=i;j>0 && chars[j].probability>chars[j-1].probability;j-- {
+      chars[j],chars[j-1] = chars[j-1],chars[j]
+    }
+  }
+  return
+}
+
+// Which S-boxes in the last round the characteristic tells us the output difference of
+// The round after the characteristic has input difference R', and only the S-boxes it doesn't touch are known
+func known_sboxes(c characteristic) (known [8]bool) {
+  groups := split6(e(c.out[4:8]))
+  for j:=0;j<8;j++ {
+    known[j] = groups[j]==0
+  }
+  return
+}
+
+// Counts, for each S-box in the last round and each of its 64 possible 6 bit subkeys, how many chosen plaintext pairs
+// agree with that subkey. 'rounds' is the number of rounds, which must be 3 more than the characteristic covers.
+// With (L',R') being the characteristic's output difference, 3 rounds later:
+// Rn' = L' ^ f(n-2)' ^ fn', and f(n-2)' is zero for S-boxes that R' doesn't reach, so for those we know fn' exactly.
+// And Ln = R(n-1) is the input to the last round's f, so we can try every subkey and see which give that fn'
+func count_subkeys(encrypt block_cipher, c characteristic, pairs int) (counts [8][64]int) {
+  known := known_sboxes(c)
+  var tables [8][64][16]int
+  for j:=0;j<8;j++ {
+    tables[j] = difference_table(sboxes[j])
+  }
+  for i:=0;i<pairs;i++ {
+    // Choose a pair with the characteristic's difference after IP
+    lr := random_bytes(8)
+    m1 := ip_reverse(lr)
+    m2 := ip_reverse(xor(lr,c.in))
+    rl1 := ip(encrypt(m1)) // Undo the final IP-1 to get RnLn
+    rl2 := ip(encrypt(m2))
+
+    // Work out the last round's S-box inputs (without the subkey) and output difference
+    in1 := split6(e(rl1[4:8]))
+    in2 := split6(e(rl2[4:8]))
+    fdiff := p_inverse(xor(xor(rl1[0:4],rl2[0:4]),c.out[0:4]))
+
+    // Wrong pairs can often be spotted: if an S-box can't possibly give the output difference, discard the pair
+    right := true
+    for j:=0;j<8;j++ {
+      dy := (fdiff[j/2]>>uint(4*(1-j%2)))&15
+      if known[j] && tables[j][in1[j]^in2[j]][dy]==0 {
+        right = false
+      }
+    }
+    if !right {
+      continue
+    }
+
+    // Each subkey that gives the right output difference gets a vote
+    for j:=0;j<8;j++ {
+      if !known[j] {
+        continue
+      }
+      dy := (fdiff[j/2]>>uint(4*(1-j%2)))&15
+      for k:=0;k<64;k++ {
+        if sboxes[j][in1[j]^byte(k)]^sboxes[j][in2[j]^byte(k)]==dy {
+          counts[j][k]++
+        }
+      }
+    }
+  }
+  return
+}
+
+// For each bit of the given round's 48 bit subkey, which of the 64 key bits it came from
+// The key schedule is just shifts and permutations, so send each key bit through on its own to see where it lands
+func subkey_bit_sources(rnd int) (sources [48]int) {
+  for kb:=0;kb<64;kb++ {
+    key := make([]byte,8)
+    set_bit(key,kb)
+    sub := expand(key)[rnd]
+    for i:=0;i<48;i++ {
+      if get_bit(sub,i)==1 {
+        sources[i] = kb
+      }
+    }
+  }
+  return
+}
+
+// Recovers the key of reduced round DES, given a chosen plaintext 'encrypt' oracle:
+// Use the characteristics to find the last round's subkey bits, one S-box (6 bits) at a time, taking the
+// subkey with the most votes. Then trace those bits back to the key, and brute force the key bits that are left
+func differential_attack(encrypt block_cipher, rounds int, chars []characteristic, pairs int) (key []byte, err error) {
+  var subkey [48]byte
+  var found [8]bool
+  for _,c := range chars {
+    counts := count_subkeys(encrypt,c,pairs)
+    known := known_sboxes(c)
+    for j:=0;j<8;j++ {
+      if !known[j] || found[j] {
+        continue
+      }
+      best := 0
+      for k:=1;k<64;k++ {
+        if counts[j][k]>counts[j][best] {
+          best = k
+        }
+      }
+      for b:=0;b<6;b++ {
+        subkey[j*6+b] = byte(best>>uint(5-b))&1
+      }
+      found[j] = true
+    }
+  }
+
+  // Put the subkey bits we found back into the key
+  key = make([]byte,8)
+  fixed := make([]bool,64)
+  sources := subkey_bit_sources(rounds-1)
+  for i:=0;i<48;i++ {
+    if found[i/6] {
+      fixed[sources[i]] = true
+      if subkey[i]==1 {
+        set_bit(key,sources[i])
+      }
+    }
+  }
+
+  // Brute force the rest (not the parity bits, the last of each byte, which DES ignores)
+  var unknown []int
+  for kb:=0;kb<64;kb++ {
+    if !fixed[kb] && kb%8!=7 {
+      unknown = append(unknown,kb)
+    }
+  }
+  if len(unknown)>24 {
+    return nil, fmt.Errorf("%d key bits left to brute force, which is too many", len(unknown))
+  }
+  m := random_bytes(8)
+  c := encrypt(m)
+  for guess:=0;guess<1<<uint(len(unknown));guess++ {
+    try := make([]byte,8)
+    copy(try,key)
+    for i,kb := range unknown {
+      if guess>>uint(i)&1==1 {
+        set_bit(try,kb)
+      }
+    }
+    if string(des_encrypt_rounds(m,expand(try),rounds,nil))==string(c) {
+      return try, nil
+    }
+  }
+  return nil, errors.New("key not found, probably a wrong subkey guess: try more pairs")
+}
+
+// Demonstrates the differential attacks on 4 and 6 round DES, against a random key
+func differential_demo() {
+  chars := find_characteristics()
+  fmt.Printf("Best 3 round characteristics:\r\n")
+  for i:=0;i<4 && i<len(chars);i++ {
+    fmt.Printf(" %X -> %X with probability 1/%.0f\r\n", chars[i].in, chars[i].out, 1/chars[i].probability)
+  }
+
+  for _,rounds := range []int{4,6} {
+    secret := random_bytes(8)
+    subkeys := expand(secret)
+    oracle := func(m []byte) []byte {
+      return des_encrypt_rounds(m,subkeys,rounds,nil)
+    }
+
+    // For 4 rounds, a 1 round characteristic is enough: with R0' = 0, round 1 always gives (0, L0')
+    // Any L0' that only reaches one S-box works, leaving the other 7 S-boxes of round 4 known
+    attack_chars := []characteristic{{to_bytes("2000000000000000"),to_bytes("0000000020000000"),1}}
+    pairs := 8
+    if rounds==6 {
+      // For 6 rounds, take the best 3 round characteristics until every S-box but at most one is covered
+      attack_chars = nil
+      var covered [8]bool
+      for _,c := range chars {
+        known := known_sboxes(c)
+        adds := 0
+        for j:=0;j<8;j++ {
+          if known[j] && !covered[j] {
+            adds++
+          }
+        }
+        if adds>=2 {
+          attack_chars = append(attack_chars,c)
+          for j:=0;j<8;j++ {
+            covered[j] = covered[j] || known[j]
+          }
+        }
+        if len(attack_chars)==2 {
+          break
+        }
+      }
+      pairs = 1000
+    }
+
+    fmt.Printf("\r\nAttacking %d round DES with %d chosen plaintext pairs per characteristic\r\n", rounds, pairs)
+    for _,c := range attack_chars {
+      fmt.Printf(" Using %X -> %X\r\n", c.in, c.out)
+    }
+    key,err := differential_attack(oracle,rounds,attack_chars,pairs)
+    for i:=0;i<8;i++ {
+      secret[i] &= 0xFE // Clear the parity bits, which DES ignores, so the keys can be compared
+    }
+    pretty("Secret key (parity bits cleared)",secret)
+    if err != nil {
+      fmt.Printf("Attack failed: %s\r\n", err)
+    } else {
+      pretty("Recovered key",key)
+    }
+  }
+}
+
+// Linear cryptanalysis (Matsui, 1993) looks for xor's of plaintext, ciphertext and key bits that come out
+// as 0 more (or less) often than half the time. The S-boxes are the only non-linear part of DES, so it comes down to
+// finding S-box inputs and outputs whose bits are related. Given enough known plaintexts, counting how often the
+// plaintext and ciphertext side comes out 0 tells us the xor of the key bits.
+// Full DES needs 2^43 known plaintexts, but 3 and 4 rounds only need a few thousand.
+// Reference: http://en.wikipedia.org/wiki/Linear_cryptanalysis
+// The linear approximation table of an S-box: entry [a][b] is how many of the 64 inputs x have the bits of x masked
+// by a xor to the same as the bits of S(x) masked by b, minus 32. So 0 means no relation, and S5's famous
+// entry [10][F] of -20 means the bits only match 12 times in 64
+func linear_table(s []byte) (t [64][16]int) {
+  for a:=0;a<64;a++ {
+    for b:=0;b<16;b++ {
+      for x:=0;x<64;x++ {
+        if parity_of(x&a)==parity_of(int(s[x])&b) {
+          t[a][b]++
+        }
+      }
+      t[a][b] -= 32
+    }
+  }
+  return
+}
+
+// Xor's all the bits of a number together
+func parity_of(x int) (p byte) {
+  for ;x>0;x>>=1 {
+    p ^= byte(x&1)
+  }
+  return
+}
+
+// Xor's together the bits of an array that are set in the mask
+func parity(in []byte, mask []byte) (p byte) {
+  for i:=range mask {
+    p ^= parity_of(int(in[i]&mask[i]))
+  }
+  return
+}
+
+// Shows an S-box's linear approximation table, one row per input mask
+func linear_table_text(n int) (s string) {
+  t := linear_table(sboxes[n-1])
+  s = fmt.Sprintf("S%d linear approximation table (rows: input mask, columns: output mask)\r\n    ", n)
+  for b:=0;b<16;b++ {
+    s += fmt.Sprintf(" %3X", b)
+  }
+  s += "\r\n"
+  for a:=0;a<64;a++ {
+    s += fmt.Sprintf("%02X: ", a)
+    for b:=0;b<16;b++ {
+      s += fmt.Sprintf(" %3d", t[a][b])
+    }
+    s += "\r\n"
+  }
+  return
+}
+
+// A linear approximation of the f function: R.alpha ^ f(R,K).beta = K.gamma, which holds with the given probability
+// (X.mask meaning the xor of the bits of X that are set in mask)
+type approximation struct {
+  sbox        int    // Which S-box it comes from (0 to 7)
+  alpha       []byte // Mask of the 32 bits of R
+  beta        []byte // Mask of the 32 bits of f's output
+  gamma       []byte // Mask of the 48 bits of the subkey
+  probability float64
+}
+
+// Finds the S-box entry furthest from 0 in any linear approximation table, and turns it into an approximation of f:
+// The S-box input is E(R)^K, so its input mask picks out bits of R (through E) and of K, and
+// its output mask picks out bits of f's output (through P)
+func best_approximation() (a approximation) {
+  best_j, best_in, best_out, best_bias := 0, 0, 0, 0
+  for j:=0;j<8;j++ {
+    t := linear_table(sboxes[j])
+    for in:=1;in<64;in++ {
+      for out:=1;out<16;out++ {
+        if t[in][out]*t[in][out] > best_bias*best_bias {
+          best_j, best_in, best_out, best_bias = j, in, out, t[in][out]
+        }
+      }
+    }
+  }
+  a.sbox = best_j
+  a.probability = float64(32+best_bias)/64
+
+  // Subkey mask: the input mask, in the S-box's 6 bits of the 48
+  a.gamma = make([]byte,6)
+  for b:=0;b<6;b++ {
+    if (best_in>>uint(5-b))&1==1 {
+      set_bit(a.gamma,best_j*6+b)
+    }
+  }
+
+  // R mask: the bits of R that E copies into those S-box input bits. Send each bit of R through E to find them
+  a.alpha = make([]byte,4)
+  for i:=0;i<32;i++ {
+    probe := make([]byte,4)
+    set_bit(probe,i)
+    if parity(e(probe),a.gamma)==1 {
+      set_bit(a.alpha,i)
+    }
+  }
+
+  // f output mask: the output mask in the S-box's 4 bits of the 32, sent through P
+  s_out := make([]byte,4)
+  s_out[best_j/2] = byte(best_out)<<uint(4*(1-best_j%2))
+  a.beta = p(s_out)
+  return
+}
+
+// Matsui's 3 round approximation is the f approximation used in rounds 1 and 3. Round 2 drops out because:
+//  Round 1: R0.alpha ^ f1.beta = K1.gamma, and f1 = R1^L0
+//  Round 3: R2.alpha ^ f3.beta = K3.gamma, and f3 = R3^R1
+//  Xor them, and R1 cancels: L0.beta ^ R0.alpha ^ L3.alpha ^ R3.beta = K1.gamma ^ K3.gamma (using R2 = L3)
+// Going by the piling-up lemma, this holds with probability 1/2 + 2*(p-1/2)^2
+func three_round_side(l0 []byte, r0 []byte, l3 []byte, r3 []byte, a approximation) byte {
+  return parity(l0,a.beta) ^ parity(r0,a.alpha) ^ parity(l3,a.alpha) ^ parity(r3,a.beta)
+}
+
+// Matsui's Algorithm 1 against 3 round DES: with known plaintexts, count how often the plaintext and ciphertext
+// side of the 3 round approximation is 0. It's 0 more often than not when the key bit K1.gamma ^ K3.gamma is 0
+// Returns that key bit, and the bias seen: how far from half the time the count was
+func matsui_algorithm1(encrypt block_cipher, a approximation, n int) (key_bit byte, bias float64) {
+  zeros := 0
+  for i:=0;i<n;i++ {
+    m := random_bytes(8)
+    l0r0 := ip(m)
+    r3l3 := ip(encrypt(m)) // Undo the final IP-1 to get R3L3
+    if three_round_side(l0r0[0:4],l0r0[4:8],r3l3[4:8],r3l3[0:4],a)==0 {
+      zeros++
+    }
+  }
+  bias = float64(zeros)/float64(n) - 0.5
+  if bias<0 {
+    key_bit = 1
   }
-  return b
+  return
 }
 
-// Pretty-print an array
-func pretty(label string, arr []byte) {
-  var s string=""
-  for i,b := range arr {
-    s += fmt.Sprintf("%02X",b)
-    if i<len(arr)-1 {
-      s += "-"
+// Matsui's Algorithm 2 against 4 round DES: use the 3 round approximation for rounds 1 to 3, and guess the 6 subkey
+// bits of round 4 that decide the bit of f4 the approximation needs (L3.alpha = (R4^f4).alpha, and R3 = L4).
+// The right guess makes the approximation hold with its full bias, wrong ones make it look random
+// Returns which S-box in round 4 was attacked, its 6 subkey bits, the key bit K1.gamma ^ K3.gamma, and the bias seen
+func matsui_algorithm2(encrypt block_cipher, a approximation, n int) (sbox int, subkey int, key_bit byte, bias float64) {
+  // Which S-box's output reaches the bits of alpha, and the mask of its 4 output bits that does
+  s_mask := p_inverse(a.alpha)
+  for j:=0;j<8;j++ {
+    if (s_mask[j/2]>>uint(4*(1-j%2)))&15 != 0 {
+      sbox = j
     }
   }
-  fmt.Printf("%s:\r\n%s\r\n", label, s)
+  out_mask := int((s_mask[sbox/2]>>uint(4*(1-sbox%2)))&15)
+
+  // Count zeros for every guess of the 6 subkey bits
+  var zeros [64]int
+  for i:=0;i<n;i++ {
+    m := random_bytes(8)
+    l0r0 := ip(m)
+    r4l4 := ip(encrypt(m))
+    in := split6(e(r4l4[4:8]))[sbox] // The S-box input, before the subkey
+    side := parity(l0r0[0:4],a.beta) ^ parity(l0r0[4:8],a.alpha) ^ parity(r4l4[0:4],a.alpha) ^ parity(r4l4[4:8],a.beta)
+    for k:=0;k<64;k++ {
+      if side ^ parity_of(int(sboxes[sbox][in^byte(k)])&out_mask)==0 { // Peel off round 4 with the guessed subkey
+        zeros[k]++
+      }
+    }
+  }
+
+  // The right guess is the one furthest from half
+  for k:=0;k<64;k++ {
+    b := float64(zeros[k])/float64(n) - 0.5
+    if b*b > bias*bias {
+      subkey, bias = k, b
+    }
+  }
+  if bias<0 {
+    key_bit = 1
+  }
+  return
+}
+
+// Demonstrates Matsui's Algorithms 1 and 2 on 3 and 4 round DES, against random keys
+func linear_demo() {
+  a := best_approximation()
+  fmt.Printf("Best approximation: S%d, holding with probability %.4f\r\n", a.sbox+1, a.probability)
+  fmt.Printf(" R.%X ^ f(R,K).%X = K.%X\r\n", a.alpha, a.beta, a.gamma)
+  p3 := 0.5 + 2*(a.probability-0.5)*(a.probability-0.5)
+  fmt.Printf("3 round approximation holds with probability %.4f\r\n", p3)
+
+  // Algorithm 1 on 3 rounds
+  n := 1000
+  subkeys := expand(random_bytes(8))
+  oracle := func(m []byte) []byte {
+    return des_encrypt_rounds(m,subkeys,3,nil)
+  }
+  bit,bias := matsui_algorithm1(oracle,a,n)
+  actual := parity(subkeys[0],a.gamma) ^ parity(subkeys[2],a.gamma)
+  fmt.Printf("\r\nAlgorithm 1 on 3 round DES with %d known plaintexts\r\n", n)
+  fmt.Printf(" Bias seen: %.4f (expected +/-%.4f, the sign giving the key bit)\r\n", bias, p3-0.5)
+  fmt.Printf(" K1.gamma ^ K3.gamma: recovered %d, actually %d\r\n", bit, actual)
+
+  // Algorithm 2 on 4 rounds
+  n = 10000
+  subkeys = expand(random_bytes(8))
+  oracle = func(m []byte) []byte {
+    return des_encrypt_rounds(m,subkeys,4,nil)
+  }
+  sbox,subkey,bit,bias := matsui_algorithm2(oracle,a,n)
+  actual = parity(subkeys[0],a.gamma) ^ parity(subkeys[2],a.gamma)
+  fmt.Printf("\r\nAlgorithm 2 on 4 round DES with %d known plaintexts\r\n", n)
+  fmt.Printf(" Bias seen with the best subkey guess: %.4f\r\n", bias)
+  fmt.Printf(" Round 4 subkey bits for S%d: recovered %06b, actually %06b\r\n", sbox+1, subkey, split6(subkeys[3])[sbox])
+  fmt.Printf(" K1.gamma ^ K3.gamma: recovered %d, actually %d\r\n", bit, actual)
+}
+
+// Exhaustive key search: DES's 56 bit key is small enough to try every key (the EFF's Deep Crack did it in 1998).
+// Here the search is over a reduced keyspace: a mask says which bits of the key are unknown, and the rest come from
+// a base key, so eg 24 unknown bits is 16 million keys. The parity bit (the bottom bit of each byte) is never used by
+// DES, so it's left out of the mask.
+// DES also has the complementation property: encrypting the complement of the plaintext with the complement of the key
+// gives the complement of the ciphertext. So with known pairs for both P and ~P, one encryption of P under K tests
+// K (does it give C?) and ~K (does it give the complement of ~P's ciphertext?). Searching all 56 bits, that halves the
+// work. With a mask, the complements of the keys searched don't match the base key, so it instead covers a second
+// keyspace for free: the keys matching the complement of the base key outside the mask.
+// Reference: http://en.wikipedia.org/wiki/Data_Encryption_Standard#Brute_force_attack
+
+// A known plaintext and the ciphertext it encrypts to
+type known_pair struct {
+  plaintext  []byte
+  ciphertext []byte
+}
+
+// Complements every bit of an array
+func complement(in []byte) (out []byte) {
+  out = make([]byte,len(in))
+  for i := range in {
+    out[i] = ^in[i]
+  }
+  return
+}
+
+// Fills the bits of index into the unknown bits of the base key: bit 0 of index into the last unknown bit, and so on
+func masked_key(base []byte, unknown []int, index uint64) []byte {
+  key := make([]byte,8)
+  copy(key,base)
+  for i:=len(unknown)-1;i>=0;i-- {
+    if index&1==1 {
+      set_bit(key,unknown[i])
+    }
+    index >>= 1
+  }
+  return key
+}
+
+// Whether a key encrypts every known plaintext to its ciphertext
+func key_fits(key []byte, pairs []known_pair) bool {
+  subkeys := expand(key)
+  for _,pair := range pairs {
+    if subtle.ConstantTimeCompare(des_encrypt(pair.plaintext,subkeys),pair.ciphertext)!=1 {
+      return false
+    }
+  }
+  return true
+}
+
+// Searches the keys matching the base key outside the mask for one that fits the known pairs, with one goroutine per
+// CPU. If the second pair's plaintext is the complement of the first's, it uses the complementation property too.
+// If a checkpoint file is given, it's written as the search goes, and a search with the same mask, base and first
+// plaintext picks up from it. It's removed once the key is found. Prints progress and the number of keys per second
+func key_search(pairs []known_pair, mask []byte, base []byte, checkpoint string) (key []byte, err error) {
+  if len(pairs)==0 {
+    return nil, errors.New("keysearch: need at least one known pair")
+  }
+
+  // The unknown bits, leaving out the parity bits, and the base key with them cleared
+  var unknown []int
+  base = append([]byte(nil),base...)
+  for i:=0;i<64;i++ {
+    if i%8!=7 && get_bit(mask,i)==1 {
+      unknown = append(unknown,i)
+      base[i/8] &^= 1<<uint(7-i%8)
+    }
+  }
+  use_complement := len(pairs)>1 && subtle.ConstantTimeCompare(pairs[1].plaintext,complement(pairs[0].plaintext))==1
+  if use_complement && len(unknown)==56 {
+    unknown = unknown[1:] // ~K covers the keys with the top bit set, so only search those without it
+  }
+  total := uint64(1)<<uint(len(unknown))
+
+  // Pick up from the checkpoint if it's for the same search
+  var next uint64
+  saved := fmt.Sprintf("%X %X %X", mask, base, pairs[0].plaintext)
+  if checkpoint != "" {
+    if data,read_err := os.ReadFile(checkpoint); read_err == nil {
+      var m,b,p string
+      if _,err = fmt.Sscanf(string(data), "%s %s %s %d", &m, &b, &p, &next); err != nil || m+" "+b+" "+p!=saved {
+        return nil, fmt.Errorf("keysearch: checkpoint %s is for a different search", checkpoint)
+      }
+      fmt.Printf("Resuming from key %d of %d\r\n", next, total)
+    }
+  }
+
+  // Test keys in batches: each CPU takes a chunk of a batch, and the checkpoint's written between batches
+  workers := uint64(runtime.NumCPU())
+  const chunk = 1<<14
+  var found []byte
+  var lock sync.Mutex
+  test := func(from uint64, to uint64) {
+    for i:=from;i<to;i++ {
+      k := masked_key(base,unknown,i)
+      c := des_encrypt(pairs[0].plaintext,expand(k))
+      var fits []byte
+      if subtle.ConstantTimeCompare(c,pairs[0].ciphertext)==1 && key_fits(k,pairs) {
+        fits = k
+      } else if use_complement && subtle.ConstantTimeCompare(complement(c),pairs[1].ciphertext)==1 && key_fits(complement(k),pairs) {
+        fits = complement(k)
+      }
+      if fits != nil {
+        lock.Lock()
+        found = fits
+        lock.Unlock()
+        return
+      }
+    }
+  }
+  start := time.Now()
+  reported := start
+  first := next
+  for next<total && found==nil {
+    var wg sync.WaitGroup
+    for w:=uint64(0);w<workers && next<total;w++ {
+      to := next+chunk
+      if to>total {
+        to = total
+      }
+      wg.Add(1)
+      go func(from uint64, to uint64) {
+        defer wg.Done()
+        test(from,to)
+      }(next,to)
+      next = to
+    }
+    wg.Wait()
+    if checkpoint != "" {
+      if err = os.WriteFile(checkpoint, []byte(fmt.Sprintf("%s %d\n", saved, next)), 0644); err != nil {
+        return nil, err
+      }
+    }
+    if time.Since(reported)>5*time.Second || next==total || found!=nil {
+      reported = time.Now()
+      searched := next-first
+      if use_complement {
+        searched *= 2 // Each encryption tested 2 keys
+      }
+      fmt.Printf("%d of %d encryptions, %.0f keys/sec\r\n", next, total, float64(searched)/time.Since(start).Seconds())
+    }
+  }
+  if found==nil {
+    return nil, errors.New("keysearch: no key in the search space fits")
+  }
+  if checkpoint != "" {
+    os.Remove(checkpoint) // The search is done, so a new search shouldn't resume from it
+  }
+  return found, nil
+}
+
+// The meet-in-the-middle attack (Diffie and Hellman, 1977) on double DES: C = E(K2, E(K1, P)) means the value in the
+// middle is both E(K1, P) and D(K2, C). So encrypt P under every K1 and keep the results in a table, then decrypt C
+// under every K2 and look each result up. A match gives a candidate K1 and K2, which the other known pairs check.
+// That's 2 * 2^n DES operations for two n bit keys, rather than 2^2n trying every pair of keys, at the cost of a
+// table of 2^n entries. Here each key has its last 'bits' key bits unknown, to keep it quick.
+// The table can be kept in memory, or on disk for when it doesn't fit: both sides are written to files split
+// into buckets by the low 6 bits of the middle value, then matched a bucket at a time, so only 1/64 of it is in memory
+// Reference: http://en.wikipedia.org/wiki/Meet-in-the-middle_attack
+
+// How many buckets the on disk table is split into
+const mitm_buckets = 64
+
+// The positions of the last n key bits, leaving out the parity bits
+func last_key_bits(n int) (positions []int) {
+  for i:=63;i>=0 && len(positions)<n;i-- {
+    if i%8!=7 {
+      positions = append([]int{i},positions...)
+    }
+  }
+  return
+}
+
+// Turns 8 bytes into a number, to use as a table key
+func block_number(b []byte) (n uint64) {
+  for _,x := range b {
+    n = n<<8 | uint64(x)
+  }
+  return
+}
+
+// Recovers both keys of double DES, given the known bits of each (base is 16 bytes: K1 then K2) with their last
+// 'bits' key bits unknown, and known pairs to check candidates against. With a directory, the table goes on disk there
+func mitm_attack(pairs []known_pair, base []byte, bits int, dir string) (key []byte, err error) {
+  if len(pairs)==0 || bits<1 || bits>56 {
+    return nil, errors.New("mitm: need at least one known pair, and 1 to 56 unknown bits")
+  }
+  unknown := last_key_bits(bits)
+  total := uint64(1)<<uint(bits)
+  forward := func(i uint64) uint64 {
+    return block_number(des_encrypt(pairs[0].plaintext,expand(masked_key(base[0:8],unknown,i))))
+  }
+  backward := func(i uint64) uint64 {
+    return block_number(des_decrypt(pairs[0].ciphertext,expand(masked_key(base[8:16],unknown,i))))
+  }
+  // Checks a candidate pair of keys against all the known pairs
+  check := func(i uint64, j uint64) []byte {
+    k := join(masked_key(base[0:8],unknown,i),masked_key(base[8:16],unknown,j))
+    for _,pair := range pairs {
+      if subtle.ConstantTimeCompare(doubledes_encrypt(pair.plaintext,k),pair.ciphertext)!=1 {
+        return nil
+      }
+    }
+    return k
+  }
+
+  if dir=="" {
+    // In memory: a map from middle values to the K1's giving them
+    table := make(map[uint64][]uint64)
+    for i:=uint64(0);i<total;i++ {
+      middle := forward(i)
+      table[middle] = append(table[middle],i)
+    }
+    for j:=uint64(0);j<total;j++ {
+      for _,i := range table[backward(j)] {
+        if key = check(i,j); key != nil {
+          return key, nil
+        }
+      }
+    }
+    return nil, errors.New("mitm: no pair of keys fits")
+  }
+
+  // On disk: write each side's middle values and key indexes to bucket files, then match them up a bucket at a time
+  bucket_file := func(side string, b int) string {
+    return fmt.Sprintf("%s/mitm_%s_%02d", dir, side, b)
+  }
+  defer func() {
+    for b:=0;b<mitm_buckets;b++ {
+      os.Remove(bucket_file("forward",b))
+      os.Remove(bucket_file("backward",b))
+    }
+  }()
+  for _,side := range []string{"forward","backward"} {
+    var files [mitm_buckets]*os.File
+    var writers [mitm_buckets]*bufio.Writer
+    for b := range files {
+      if files[b],err = os.Create(bucket_file(side,b)); err != nil {
+        return nil, err
+      }
+      defer files[b].Close()
+      writers[b] = bufio.NewWriter(files[b])
+    }
+    for i:=uint64(0);i<total;i++ {
+      var middle uint64
+      if side=="forward" {
+        middle = forward(i)
+      } else {
+        middle = backward(i)
+      }
+      record := make([]byte,16)
+      binary.BigEndian.PutUint64(record[0:8],middle)
+      binary.BigEndian.PutUint64(record[8:16],i)
+      if _,err = writers[middle%mitm_buckets].Write(record); err != nil {
+        return nil, err
+      }
+    }
+    for b := range writers {
+      if err = writers[b].Flush(); err != nil {
+        return nil, err
+      }
+    }
+  }
+  for b:=0;b<mitm_buckets;b++ {
+    var f,g []byte
+    if f,err = os.ReadFile(bucket_file("forward",b)); err != nil {
+      return nil, err
+    }
+    if g,err = os.ReadFile(bucket_file("backward",b)); err != nil {
+      return nil, err
+    }
+    table := make(map[uint64][]uint64)
+    for r:=0;r+16<=len(f);r+=16 {
+      middle := binary.BigEndian.Uint64(f[r:r+8])
+      table[middle] = append(table[middle],binary.BigEndian.Uint64(f[r+8:r+16]))
+    }
+    for r:=0;r+16<=len(g);r+=16 {
+      for _,i := range table[binary.BigEndian.Uint64(g[r:r+8])] {
+        if key = check(i,binary.BigEndian.Uint64(g[r+8:r+16])); key != nil {
+          return key, nil
+        }
+      }
+    }
+  }
+  return nil, errors.New("mitm: no pair of keys fits")
+}
+
+// Demonstrates the meet-in-the-middle attack on double DES with random keys, each with 'bits' unknown bits
+func mitm_demo(bits int, dir string) error {
+  secret := random_bytes(16)
+  base := append([]byte(nil),secret...)
+  for _,i := range last_key_bits(bits) {
+    base[i/8] &^= 1<<uint(7-i%8)   // Forget K1's unknown bits
+    base[8+i/8] &^= 1<<uint(7-i%8) // And K2's
+  }
+  var pairs []known_pair
+  for i:=0;i<2;i++ {
+    m := random_bytes(8)
+    pairs = append(pairs,known_pair{m,doubledes_encrypt(m,secret)})
+  }
+
+  start := time.Now()
+  key,err := mitm_attack(pairs,base,bits,dir)
+  if err != nil {
+    return err
+  }
+  pretty("Recovered keys", key)
+  pretty("Actual keys", secret)
+  fmt.Printf("Took %.1f seconds and at most %d DES operations, instead of %d trying every pair of keys\r\n",
+    time.Since(start).Seconds(), uint64(2)<<uint(bits), uint64(1)<<uint(2*bits))
+  return nil
 }
 
 // Test the crypto implementation
+// Also has some command line tools:
+//  go_des trace key msg - Show every round of a DES encryption, eg go_des trace 133457799BBCDFF1 0123456789ABCDEF
+//  go_des lmaudit wordlist.txt hashes.txt - Audit a file of LM hashes against a wordlist
+//  go_des ddt n - Show the difference distribution table of S-box n (1 to 8)
+//  go_des differential - Find characteristics and break 4 and 6 round DES by differential cryptanalysis
+//  go_des lat n - Show the linear approximation table of S-box n (1 to 8)
+//  go_des linear - Find the best linear approximation and attack 3 and 4 round DES with Matsui's algorithms
+//  go_des keysearch mask base checkpoint.txt|- plaintext ciphertext [plaintext ciphertext...] - Search the keys
+//    matching base outside the mask for one that fits the known pairs, saving progress to a checkpoint file (or not,
+//    with -). Give a second pair with the complemented plaintext to use the complementation property, eg:
+//    go_des keysearch 00000000FFFFFF00 13345779000000F1 - 0123456789ABCDEF 85E813540F0AB405
+//  go_des mitm bits [dir] - Break double DES with random keys with the last 'bits' bits of each unknown, by the
+//    meet-in-the-middle attack, keeping the table in memory or in files in a directory
 func main() {
+  if len(os.Args)>1 {
+    var err error
+    switch {
+    case len(os.Args)==3 && os.Args[1]=="ddt" && len(os.Args[2])==1 && os.Args[2][0]>='1' && os.Args[2][0]<='8':
+      fmt.Print(difference_table_text(int(os.Args[2][0]-'0')))
+    case len(os.Args)==2 && os.Args[1]=="differential":
+      differential_demo()
+    case len(os.Args)==3 && os.Args[1]=="lat" && len(os.Args[2])==1 && os.Args[2][0]>='1' && os.Args[2][0]<='8':
+      fmt.Print(linear_table_text(int(os.Args[2][0]-'0')))
+    case len(os.Args)==2 && os.Args[1]=="linear":
+      linear_demo()
+    case len(os.Args)==4 && os.Args[1]=="trace":
+      var k,m []byte
+      if k,err = parse_hex(os.Args[2],8); err == nil {
+        if m,err = parse_hex(os.Args[3],8); err == nil {
+          fmt.Print(des_trace_table(m,k))
+        }
+      }
+    case len(os.Args)==4 && os.Args[1]=="lmaudit":
+      err = lm_audit(os.Args[2],os.Args[3])
+    case (len(os.Args)==3 || len(os.Args)==4) && os.Args[1]=="mitm":
+      var bits int
+      if _,err = fmt.Sscanf(os.Args[2], "%d", &bits); err == nil {
+        dir := ""
+        if len(os.Args)==4 {
+          dir = os.Args[3]
+        }
+        err = mitm_demo(bits,dir)
+      }
+    case len(os.Args)>=7 && len(os.Args)%2==1 && os.Args[1]=="keysearch":
+      var mask,base,key []byte
+      var pairs []known_pair
+      if mask,err = parse_hex(os.Args[2],8); err == nil {
+        base,err = parse_hex(os.Args[3],8)
+      }
+      for i:=5;i<len(os.Args) && err == nil;i+=2 {
+        var pair known_pair
+        if pair.plaintext,err = parse_hex(os.Args[i],8); err == nil {
+          pair.ciphertext,err = parse_hex(os.Args[i+1],8)
+        }
+        pairs = append(pairs,pair)
+      }
+      if err == nil {
+        checkpoint := os.Args[4]
+        if checkpoint=="-" {
+          checkpoint = ""
+        }
+        if key,err = key_search(pairs,mask,base,checkpoint); err == nil {
+          pretty("Found key", key)
+        }
+      }
+    default:
+      err = errors.New("unknown command, see the comment above main() for usage")
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    return
+  }
+
   println("Test DES");
 
   key := to_bytes("133457799BBCDFF1")
@@ -327,4 +1390,53 @@ func main() {
   d3d := tripledes_decrypt(e3d,k3d)
   pretty("Encrypted (should be 3A-3A-CE-65-0D-B3-BB-DC)",e3d);
   pretty("Decrypted (should be 12-34-56-78-90-AB-CD-EF)",d3d);
+
+
+  println("\r\nTest DESX")
+  kx := to_bytes("133457799BBCDFF10123456789ABCDEFFEDCBA9876543210")
+  ex := desx_encrypt(msg,kx)
+  pretty("Encrypted (should be 6A-56-F9-61-FC-D7-7D-6E)",ex)
+  pretty("Decrypted (should be 01-23-45-67-89-AB-CD-EF)",desx_decrypt(ex,kx))
+  tdes_enc := func(block []byte) []byte {
+    return tripledes_encrypt(block,k3d)
+  }
+  tdes_dec := func(block []byte) []byte {
+    return tripledes_decrypt(block,k3d)
+  }
+  ewx := whiten(tdes_enc,kx[8:16],kx[16:24])(m3d)
+  pretty("Whitened triple DES",ewx)
+  pretty("Unwhitened (should be 12-34-56-78-90-AB-CD-EF)",unwhiten(tdes_dec,kx[8:16],kx[16:24])(ewx))
+
+
+  println("\r\nTest TR-31 key block (version B)")
+  kbpk := to_bytes("89E88CF7931444F334BD7547FC3F380C")
+  h := tr31_header{'B',"P0",'T','E',"00",'E'}
+  block,err := tr31_wrap(tr31_b,kbpk,h,k3d)
+  if err != nil {
+    fmt.Printf("Wrap failed: %s\r\n", err)
+    return
+  }
+  fmt.Printf("Key block:\r\n%s\r\n", block)
+  _,unwrapped,err := tr31_unwrap(tr31_b,kbpk,block)
+  if err != nil {
+    fmt.Printf("Unwrap failed: %s\r\n", err)
+    return
+  }
+  pretty("Unwrapped (should be 11-22-33-44-55-66-77-89-87-98-79-45-35-21-35-44)",unwrapped)
+  _,_,err = tr31_unwrap(tr31_b,kbpk,block[:11]+"N"+block[12:]) // Try to make the key non-exportable
+  fmt.Printf("Tampered header (should fail): %v\r\n", err)
+
+
+  println("\r\nTest crypt(3)")
+  hash,_ := unix_crypt("password","ab")
+  fmt.Printf("Traditional (should be abJnggxhB/yWI):\r\n%s\r\n", hash)
+  hash,_ = bsdi_crypt("U*U*U*U*","_J9..CCCC")
+  fmt.Printf("Extended (should be _J9..CCCCXBrJUJV154M):\r\n%s\r\n", hash)
+  fmt.Printf("Verify right password (should be true): %v\r\n", crypt_verify("ab1234567","_J9..SDizh.vll5VED9g"))
+  fmt.Printf("Verify wrong password (should be false): %v\r\n", crypt_verify("ab1234568","_J9..SDizh.vll5VED9g"))
+
+
+  println("\r\nTest LM hash")
+  pretty("Empty password (should be AA-D3-B4-35-B5-14-04-EE-AA-D3-B4-35-B5-14-04-EE)", lm_hash(""))
+  pretty("'password' (should be E5-2C-AC-67-41-9A-9A-22-4A-3B-10-8F-3F-A6-CB-6D)", lm_hash("password"))
 }
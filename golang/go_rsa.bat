@@ -1,7 +1,7 @@
 @echo off
 if exist go_rsa.8 del go_rsa.8
 if exist go_rsa.exe del go_rsa.exe
-8g go_rsa.go
+8g go_rsa.go shared_aes.go shared_des.go shared_rsa.go shared_tr31.go shared_util.go
 if exist go_rsa.8 8l -o go_rsa.exe go_rsa.8
 if exist go_rsa.exe go_rsa.exe
 pause
\ No newline at end of file
@@ -1,82 +1,1835 @@
-// Simple, thoroughly commented implementation of 1024-bit RSA using Google Go aka Golang
+// Simple, thoroughly commented RSA using Google Go aka Golang: keys up to 16384 bits, PKCS#1 v1.5, OAEP and PSS padding,
+// PEM, PKCS#8, OpenSSH and JWK key files, and experiments with the CRT, blinding and timing
 // Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog
 // http://github.com/chrishulbert/crypto
+// The keys and the raw RSA operations are in shared_rsa.go, and encrypting private keys uses the AES in
+// shared_aes.go, so build this along with the shared files:
+//  go run go_rsa.go shared_*.go
 // References:
 //  http://www.di-mgt.com.au/rsa_alg.html
 //  http://islab.oregonstate.edu/koc/ece575/02Project/Mor/
 //  http://people.csail.mit.edu/rivest/Rsapaper.pdf
 
 package main
-import "fmt"  // For printf
-import "big"  // For the big numbers required for RSA
-import "rand" // So we can create random numbers (non-crypto-secure, however)
-import "time" // For the nanoseconds to use for seeding the randomiser
-
-// Make a random bignum of size bits, with the highest two and low bit set
-func create_random_bignum(bits int) (num *big.Int) {
-  num = big.NewInt(3) // Start with 3 so the highest 2 bits are set
-  one := big.NewInt(1) // Constant of one
-  for num.BitLen() < bits-1 { // Add bits until we're 1 less than we need to be
-    num.Lsh(num,1) // num <<= 1 (increase the bitsize by 1)
-    if rand.Int() & 1 == 1 { // set the lowest bit randomly
-      num.Add(num, one) // num += 1
-    }
-  }
-  num.Lsh(num,1) // num <<= 1 (increase the bitsize by 1)
-  num.Add(num,big.NewInt(1)) // num++ - so the lowest bit is set
+import "fmt"             // For printf
+import "math/big"        // For the big numbers required for RSA
+import "crypto/rand"     // So we can create secure random numbers
+import "errors"          // For the key validation errors
+import "os"              // For the command line tools
+import "strconv"         // For parsing command line numbers
+import "strings"         // For reading authorized_keys lines and test fixtures
+import "slices"          // For finding the key type in authorized_keys lines
+import "io"              // For the sources of randomness
+import "time"            // For timing decryption
+import "crypto/subtle"   // For checking padding in constant time
+import "crypto"          // For choosing hashes
+import _ "crypto/sha1"   // Makes SHA-1 available through crypto.SHA1
+import _ "crypto/sha256" // And SHA-256
+import _ "crypto/sha512" // And SHA-512
+import "crypto/rsa"      // For checking against the standard library
+import "encoding/hex"    // For reading test fixtures
+import "bytes"           // For feeding test vectors' seeds in as the randomness
+import "encoding/pem"    // For saving keys as text
+import "crypto/x509"     // For checking key files against the standard library
+import "encoding/binary" // For the SSH key formats' numbers
+import "encoding/base64" // For SSH and JSON Web Keys
+import "encoding/json"   // For JSON Web Keys
+import "crypto/hmac"     // For PBKDF2, to encrypt private keys with a password
+
+// Textbook RSA (c = m^e mod n on the raw message) is deterministic, so the same message always encrypts the same way,
+// and it's malleable: multiplying ciphertexts multiplies the messages. PKCS#1 v1.5 (RFC 8017) pads messages first.
+// Reference: http://tools.ietf.org/html/rfc8017
+
+// Turns a number into a big-endian byte array of the given length (RFC 8017's I2OSP)
+func i2osp(x *big.Int, length int) []byte {
+  return x.FillBytes(make([]byte, length))
+}
+
+// Turns a big-endian byte array into a number (RFC 8017's OS2IP)
+func os2ip(b []byte) *big.Int {
+  return new(big.Int).SetBytes(b)
+}
+
+// The length of the modulus in bytes, which is the length of every ciphertext and signature
+func (pub *PublicKey) size() int {
+  return (pub.N.BitLen()+7)/8
+}
+
+// Encrypts a message with PKCS#1 v1.5 padding (RFC 8017 7.2.1). The padded message is:
+//  00 02 PS 00 M
+// PS is at least 8 random nonzero bytes, filling it out to the length of the modulus. So the message can be at most
+// 11 bytes less than that. The 00 at the start keeps it less than n, and the 02 says it's for encrypting
+func EncryptPKCS1v15(random io.Reader, pub *PublicKey, msg []byte) ([]byte, error) {
+  k := pub.size()
+  if len(msg)>k-11 {
+    return nil, errors.New("rsa: message too long for the key")
+  }
+  em := make([]byte, k)
+  em[1] = 2
+  ps := em[2:k-len(msg)-1]
+  if _, err := io.ReadFull(random, ps); err != nil {
+    return nil, err
+  }
+  for i := range ps { // Replace any zero bytes, since a zero marks the end of the padding
+    for ps[i]==0 {
+      if _, err := io.ReadFull(random, ps[i:i+1]); err != nil {
+        return nil, err
+      }
+    }
+  }
+  copy(em[k-len(msg):], msg)
+  return i2osp(pub.encrypt(os2ip(em)), k), nil
+}
+
+// Decrypts a message with PKCS#1 v1.5 padding (RFC 8017 7.2.2). Whatever's wrong, it gives the same error, and checks
+// the whole of the padding rather than stopping at the first problem: telling an attacker which ciphertexts decrypt
+// to valid padding lets them decrypt anything, a byte at a time (Bleichenbacher's attack, 1998)
+func DecryptPKCS1v15(priv *PrivateKey, ciphertext []byte) ([]byte, error) {
+  k := priv.size()
+  c := os2ip(ciphertext)
+  if len(ciphertext)!=k || k<11 || c.Cmp(priv.N)>=0 {
+    return nil, errors.New("rsa: decryption error")
+  }
+  m, err := priv.decrypt(c)
+  if err != nil {
+    return nil, err
+  }
+  em := i2osp(m, k)
+
+  // Find the 00 after the padding, looking at every byte whether or not it's already been found
+  good := subtle.ConstantTimeByteEq(em[0], 0) & subtle.ConstantTimeByteEq(em[1], 2)
+  found, index := 0, 0
+  for i:=2;i<k;i++ {
+    zero := subtle.ConstantTimeByteEq(em[i], 0)
+    index = subtle.ConstantTimeSelect(zero&^found, i, index)
+    found |= zero
+  }
+  good &= found & subtle.ConstantTimeLessOrEq(10, index) // At least 8 bytes of padding
+  if good!=1 {
+    return nil, errors.New("rsa: decryption error")
+  }
+  return em[index+1:], nil
+}
+
+// The start of the DER encoded DigestInfo for each hash: a SEQUENCE of the hash's algorithm identifier and an
+// OCTET STRING, which the hash itself completes (RFC 8017 9.2, note 1)
+var digest_info_prefixes = map[crypto.Hash][]byte{
+  crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+  crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+  crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Pads a hash for signing with PKCS#1 v1.5 (RFC 8017 9.2, EMSA-PKCS1-v1_5):
+//  00 01 FF..FF 00 DigestInfo
+// The DigestInfo says which hash was used, so a signature can't be passed off as one using a weaker hash
+func pkcs1v15_sign_padding(hash crypto.Hash, hashed []byte, k int) ([]byte, error) {
+  prefix, ok := digest_info_prefixes[hash]
+  if !ok || len(hashed)!=hash.Size() {
+    return nil, errors.New("rsa: unsupported hash, or the hashed message is the wrong length")
+  }
+  t_len := len(prefix)+len(hashed)
+  if k<t_len+11 {
+    return nil, errors.New("rsa: key too short for the hash")
+  }
+  em := make([]byte, k)
+  em[1] = 1
+  for i:=2;i<k-t_len-1;i++ {
+    em[i] = 0xff
+  }
+  copy(em[k-t_len:], prefix)
+  copy(em[k-len(hashed):], hashed)
+  return em, nil
+}
+
+// Signs a hash (eg sha256.Sum256 of the message) with PKCS#1 v1.5 padding (RFC 8017 8.2.1). The padding has no
+// randomness, so the same message and key always give the same signature
+func SignPKCS1v15(priv *PrivateKey, hash crypto.Hash, hashed []byte) ([]byte, error) {
+  k := priv.size()
+  em, err := pkcs1v15_sign_padding(hash, hashed, k)
+  if err != nil {
+    return nil, err
+  }
+  s, err := priv.decrypt(os2ip(em)) // Signing is the same sum as decrypting
+  if err != nil {
+    return nil, err
+  }
+  return i2osp(s, k), nil
+}
+
+// Verifies a PKCS#1 v1.5 signature of a hash (RFC 8017 8.2.2): encrypting the signature must give exactly the padding
+// signing would make. Building the expected padding and comparing, rather than parsing what comes out, avoids
+// accepting forged signatures with junk hidden in them (Bleichenbacher's e = 3 forgery, 2006)
+func VerifyPKCS1v15(pub *PublicKey, hash crypto.Hash, hashed []byte, sig []byte) error {
+  k := pub.size()
+  s := os2ip(sig)
+  if len(sig)!=k || s.Cmp(pub.N)>=0 {
+    return errors.New("rsa: verification error")
+  }
+  expected, err := pkcs1v15_sign_padding(hash, hashed, k)
+  if err != nil {
+    return err
+  }
+  if subtle.ConstantTimeCompare(i2osp(pub.encrypt(s), k), expected)!=1 {
+    return errors.New("rsa: verification error")
+  }
+  return nil
+}
+
+// PKCS#1 v1.5 encryption padding only has a little structure, which is what let Bleichenbacher's attack work. OAEP
+// (Bellare and Rogaway, 1994, in RFC 8017 7.1) mixes the whole padded message up with a random seed, so a ciphertext
+// that wasn't made by encrypting properly almost never decrypts to anything valid, and it's provably secure.
+// Reference: http://en.wikipedia.org/wiki/Optimal_asymmetric_encryption_padding
+
+// The mask generation function MGF1 (RFC 8017 B.2.1): stretches a seed to any length by hashing it with a 4 byte
+// counter appended, for counter = 0, 1, 2 ... and joining the hashes together
+func mgf1(hash crypto.Hash, seed []byte, length int) []byte {
+  var out []byte
+  for counter:=uint32(0);len(out)<length;counter++ {
+    h := hash.New()
+    h.Write(seed)
+    h.Write([]byte{byte(counter>>24), byte(counter>>16), byte(counter>>8), byte(counter)})
+    out = h.Sum(out)
+  }
+  return out[:length]
+}
+
+// Encrypts a message with OAEP padding (RFC 8017 7.1.1). The label is optional, and just gets checked on decrypting,
+// eg to tie a ciphertext to what it's for. With k the length of the modulus and h of the hash, the padded message is:
+//  DB = hash(label) || 00..00 || 01 || message         k-h-1 bytes, so the message can be at most k-2h-2 bytes
+//  masked DB = DB xor MGF1(seed)                       seed is h random bytes
+//  masked seed = seed xor MGF1(masked DB)
+//  padded = 00 || masked seed || masked DB
+func EncryptOAEP(hash crypto.Hash, random io.Reader, pub *PublicKey, msg []byte, label []byte) ([]byte, error) {
+  k, h_len := pub.size(), hash.Size()
+  if len(msg)>k-2*h_len-2 {
+    return nil, errors.New("rsa: message too long for the key")
+  }
+  db := make([]byte, k-h_len-1)
+  copy(db, hash_message(hash, label))
+  db[len(db)-len(msg)-1] = 1
+  copy(db[len(db)-len(msg):], msg)
+  seed := make([]byte, h_len)
+  if _, err := io.ReadFull(random, seed); err != nil {
+    return nil, err
+  }
+  masked_db := xor(db, mgf1(hash, seed, len(db)))
+  masked_seed := xor(seed, mgf1(hash, masked_db, h_len))
+  em := append(append([]byte{0}, masked_seed...), masked_db...)
+  return i2osp(pub.encrypt(os2ip(em)), k), nil
+}
+
+// Decrypts a message with OAEP padding (RFC 8017 7.1.2), undoing the masks the same way they were made. Like
+// DecryptPKCS1v15, it gives the same error whatever's wrong, and looks at all of the padding every time, since
+// telling an attacker which part was wrong lets them decrypt anything (Manger's attack, 2001)
+func DecryptOAEP(hash crypto.Hash, priv *PrivateKey, ciphertext []byte, label []byte) ([]byte, error) {
+  k, h_len := priv.size(), hash.Size()
+  c := os2ip(ciphertext)
+  if len(ciphertext)!=k || k<2*h_len+2 || c.Cmp(priv.N)>=0 {
+    return nil, errors.New("rsa: decryption error")
+  }
+  m, err := priv.decrypt(c)
+  if err != nil {
+    return nil, errors.New("rsa: decryption error")
+  }
+  em := i2osp(m, k)
+  masked_seed, masked_db := em[1:1+h_len], em[1+h_len:]
+  seed := xor(masked_seed, mgf1(hash, masked_db, h_len))
+  db := xor(masked_db, mgf1(hash, seed, len(masked_db)))
+
+  // The first byte has to be 0, then the label's hash, then zeros up to a 1
+  good := subtle.ConstantTimeByteEq(em[0], 0)
+  good &= subtle.ConstantTimeCompare(db[:h_len], hash_message(hash, label))
+  looking, index, invalid := 1, 0, 0
+  for i:=h_len;i<len(db);i++ {
+    zero := subtle.ConstantTimeByteEq(db[i], 0)
+    one := subtle.ConstantTimeByteEq(db[i], 1)
+    index = subtle.ConstantTimeSelect(looking&one, i, index)
+    invalid |= looking &^ zero &^ one // Anything but 0 or 1 before the 1
+    looking &^= one
+  }
+  if good&^invalid&^looking!=1 {
+    return nil, errors.New("rsa: decryption error")
+  }
+  return db[index+1:], nil
+}
+
+// PKCS#1 v1.5 signatures have no randomness and no security proof. PSS (Bellare and Rogaway, 1996, in RFC 8017 8.1)
+// hashes the message with a random salt and masks it like OAEP does, and is provably secure.
+// Reference: http://en.wikipedia.org/wiki/Probabilistic_signature_scheme
+
+// Salt lengths with special meanings, the same as the standard library's. Otherwise it's the number of salt bytes
+const (
+  PSSSaltLengthAuto = 0        // Signing: as long as the key allows. Verifying: work it out from the signature
+  PSSSaltLengthEqualsHash = -1 // The same length as the hash, which is the usual choice
+)
+
+// Pads a hash for signing with PSS (RFC 8017 9.1.1, EMSA-PSS-encode). With h the length of the hash, and em_bits
+// one less than the bits of the modulus so the result is less than n:
+//  H = hash(00 00 00 00 00 00 00 00 || hashed message || salt)
+//  DB = 00..00 || 01 || salt
+//  padded = (DB xor MGF1(H)) || H || BC                 with the top bits past em_bits cleared
+func pss_encode(hash crypto.Hash, hashed []byte, salt []byte, em_bits int) ([]byte, error) {
+  h_len, em_len := hash.Size(), (em_bits+7)/8
+  if len(hashed)!=h_len || em_len<h_len+len(salt)+2 {
+    return nil, errors.New("rsa: key too short for the hash and salt, or the hashed message is the wrong length")
+  }
+  h := hash.New()
+  h.Write(make([]byte, 8))
+  h.Write(hashed)
+  h.Write(salt)
+  H := h.Sum(nil)
+  db := make([]byte, em_len-h_len-1)
+  db[len(db)-len(salt)-1] = 1
+  copy(db[len(db)-len(salt):], salt)
+  masked_db := xor(db, mgf1(hash, H, len(db)))
+  masked_db[0] &= 0xff>>uint(8*em_len-em_bits)
+  return append(append(masked_db, H...), 0xbc), nil
+}
+
+// Signs a hash (eg sha256.Sum256 of the message) with PSS (RFC 8017 8.1.1). The salt length can be a number of
+// bytes, PSSSaltLengthEqualsHash, or PSSSaltLengthAuto to use as much salt as fits
+func SignPSS(random io.Reader, priv *PrivateKey, hash crypto.Hash, hashed []byte, salt_length int) ([]byte, error) {
+  em_bits := priv.N.BitLen()-1
+  switch salt_length {
+  case PSSSaltLengthAuto:
+    salt_length = (em_bits+7)/8 - hash.Size() - 2
+  case PSSSaltLengthEqualsHash:
+    salt_length = hash.Size()
+  }
+  if salt_length<0 {
+    return nil, errors.New("rsa: bad salt length")
+  }
+  salt := make([]byte, salt_length)
+  if _, err := io.ReadFull(random, salt); err != nil {
+    return nil, err
+  }
+  em, err := pss_encode(hash, hashed, salt, em_bits)
+  if err != nil {
+    return nil, err
+  }
+  s, err := priv.decrypt(os2ip(em)) // Signing is the same sum as decrypting
+  if err != nil {
+    return nil, err
+  }
+  return i2osp(s, priv.size()), nil
+}
+
+// Verifies a PSS signature of a hash (RFC 8017 8.1.2 and 9.1.2). Unmasks DB to get the salt back, then checks that
+// hashing with it gives H. The salt length can be a number of bytes, PSSSaltLengthEqualsHash, or PSSSaltLengthAuto
+// to take whatever follows the 01 in DB
+func VerifyPSS(pub *PublicKey, hash crypto.Hash, hashed []byte, sig []byte, salt_length int) error {
+  fail := errors.New("rsa: verification error")
+  s := os2ip(sig)
+  if len(sig)!=pub.size() || s.Cmp(pub.N)>=0 {
+    return fail
+  }
+  em_bits := pub.N.BitLen()-1
+  h_len, em_len := hash.Size(), (em_bits+7)/8
+  m := pub.encrypt(s)
+  if m.BitLen()>em_bits || em_len<h_len+2 || len(hashed)!=h_len {
+    return fail
+  }
+  em := i2osp(m, em_len)
+  if em[em_len-1]!=0xbc {
+    return fail
+  }
+  masked_db, H := em[:em_len-h_len-1], em[em_len-h_len-1:em_len-1]
+  db := xor(masked_db, mgf1(hash, H, len(masked_db)))
+  db[0] &= 0xff>>uint(8*em_len-em_bits)
+
+  // Find the 01 after the zeros: where it should be for the salt length, or wherever it is for auto
+  one := 0
+  for one<len(db) && db[one]==0 {
+    one++
+  }
+  if salt_length==PSSSaltLengthEqualsHash {
+    salt_length = h_len
+  }
+  if one==len(db) || db[one]!=1 || (salt_length!=PSSSaltLengthAuto && len(db)-one-1!=salt_length) {
+    return fail
+  }
+  expected, err := pss_encode(hash, hashed, db[one+1:], em_bits)
+  if err != nil || subtle.ConstantTimeCompare(expected[len(expected)-h_len-1:len(expected)-1], H)!=1 {
+    return fail
+  }
+  return nil
+}
+
+// Keys are saved in files as ASN.1 (a language for describing structures) encoded with DER (Distinguished Encoding
+// Rules, X.690), usually wrapped in PEM (base64 between -----BEGIN and -----END lines). DER writes every value as:
+//  tag (1 byte: what type it is), length (of the contents), contents
+// The length is one byte if it's under 128, otherwise 0x80 + how many bytes the length takes, then the length.
+// DER allows exactly one encoding of anything, so lengths must be as short as possible. A SEQUENCE's contents are
+// just its members encoded one after another.
+// Reference: http://luca.ntop.org/Teaching/Appunti/asn1.html (A Layman's Guide to a Subset of ASN.1, BER, and DER)
+const (
+  der_integer_tag = 0x02
+  der_bit_string_tag = 0x03
+  der_octet_string_tag = 0x04
+  der_null_tag = 0x05
+  der_oid_tag = 0x06
+  der_sequence_tag = 0x30 // 0x10, with 0x20 set because it's made of other values
+)
+
+// Encodes a value: tag, length, contents
+func der_element(tag byte, contents []byte) []byte {
+  var length []byte
+  if len(contents)<0x80 {
+    length = []byte{byte(len(contents))}
+  } else {
+    for n:=len(contents); n>0; n>>=8 {
+      length = append([]byte{byte(n)}, length...)
+    }
+    length = append([]byte{0x80|byte(len(length))}, length...)
+  }
+  return append(append([]byte{tag}, length...), contents...)
+}
+
+// Encodes an INTEGER. They're big-endian two's complement, so a positive number whose top bit is set gets an extra
+// zero byte in front, or it would read as negative
+func der_integer(x *big.Int) []byte {
+  contents := x.Bytes()
+  if len(contents)==0 || contents[0]&0x80!=0 {
+    contents = append([]byte{0}, contents...)
+  }
+  return der_element(der_integer_tag, contents)
+}
+
+// Encodes a SEQUENCE of already encoded values
+func der_sequence(members ...[]byte) []byte {
+  return der_element(der_sequence_tag, bytes.Join(members, nil))
+}
+
+// Encodes an OBJECT IDENTIFIER, eg 1.2.840.113549.1.1.1 for rsaEncryption. The first two numbers share a byte
+// (40*first + second), and the rest are base 128 with the top bit set on every byte but the last
+func der_oid(arcs ...int) []byte {
+  contents := []byte{byte(40*arcs[0]+arcs[1])}
+  for _, arc := range arcs[2:] {
+    encoded := []byte{byte(arc&0x7f)}
+    for arc>>=7; arc>0; arc>>=7 {
+      encoded = append([]byte{0x80|byte(arc&0x7f)}, encoded...)
+    }
+    contents = append(contents, encoded...)
+  }
+  return der_element(der_oid_tag, contents)
+}
+
+// A decoded value: its tag and contents
+type der_value struct {
+  tag byte
+  contents []byte
+}
+
+// Decodes the value at the start of data, returning what's left after it. Rejects anything DER doesn't allow,
+// eg lengths that could be shorter, or BER's "indefinite length" (0x80) that runs until an end marker
+func der_parse(data []byte) (value der_value, rest []byte, err error) {
+  bad := errors.New("asn1: bad DER")
+  if len(data)<2 || data[0]&0x1f==0x1f { // Tags over 30 take more bytes, and keys don't use them
+    return value, nil, bad
+  }
+  value.tag = data[0]
+  length, data := int(data[1]), data[2:]
+  if length>=0x80 {
+    size := length&0x7f
+    if size==0 || size>4 || size>len(data) || data[0]==0 {
+      return value, nil, bad
+    }
+    length = 0
+    for _, b := range data[:size] {
+      length = length<<8 | int(b)
+    }
+    if length<0x80 {
+      return value, nil, bad
+    }
+    data = data[size:]
+  }
+  if length>len(data) {
+    return value, nil, bad
+  }
+  return der_value{value.tag, data[:length]}, data[length:], nil
+}
+
+// Decodes a whole DER structure, which must be a value of the given tag with nothing after it
+func der_parse_all(data []byte, tag byte) (der_value, error) {
+  value, rest, err := der_parse(data)
+  if err == nil && (value.tag!=tag || len(rest)!=0) {
+    err = errors.New("asn1: unexpected structure")
+  }
+  return value, err
+}
+
+// Decodes a SEQUENCE's members, however many there are
+func (v der_value) all_members() ([]der_value, error) {
+  if v.tag!=der_sequence_tag {
+    return nil, errors.New("asn1: expected a SEQUENCE")
+  }
+  var members []der_value
+  for rest := v.contents; len(rest)>0; {
+    var member der_value
+    var err error
+    if member, rest, err = der_parse(rest); err != nil {
+      return nil, err
+    }
+    members = append(members, member)
+  }
+  return members, nil
+}
+
+// Decodes a SEQUENCE's members, checking their tags
+func (v der_value) members(tags ...byte) ([]der_value, error) {
+  members, err := v.all_members()
+  if err != nil {
+    return nil, err
+  }
+  if len(members)!=len(tags) {
+    return nil, errors.New("asn1: wrong number of members in a SEQUENCE")
+  }
+  for i, member := range members {
+    if member.tag!=tags[i] {
+      return nil, errors.New("asn1: unexpected tag in a SEQUENCE")
+    }
+  }
+  return members, nil
+}
+
+// Encodes a value again, eg to compare it with an OID
+func (v der_value) encoded() []byte {
+  return der_element(v.tag, v.contents)
+}
+
+// Decodes a non-negative INTEGER, rejecting extra leading zero bytes
+func (v der_value) integer() (*big.Int, error) {
+  c := v.contents
+  if v.tag!=der_integer_tag || len(c)==0 || c[0]&0x80!=0 || (len(c)>1 && c[0]==0 && c[1]&0x80==0) {
+    return nil, errors.New("asn1: bad INTEGER")
+  }
+  return new(big.Int).SetBytes(c), nil
+}
+
+// Decodes several INTEGERs at once
+func der_integers(values []der_value) ([]*big.Int, error) {
+  numbers := make([]*big.Int, len(values))
+  for i, v := range values {
+    var err error
+    if numbers[i], err = v.integer(); err != nil {
+      return nil, err
+    }
+  }
+  return numbers, nil
+}
+
+// The public exponent, checked it fits in an int
+func der_exponent(e *big.Int) (int, error) {
+  if e.BitLen()>31 || e.Sign()==0 {
+    return 0, errors.New("rsa: public exponent out of range")
+  }
+  return int(e.Int64()), nil
+}
+
+// The AlgorithmIdentifier saying a key is RSA: SEQUENCE { rsaEncryption OID, NULL parameters }
+var rsa_algorithm = der_sequence(der_oid(1, 2, 840, 113549, 1, 1, 1), der_element(der_null_tag, nil))
+
+// The key formats, from RFC 8017 appendix A.1 (PKCS#1), RFC 5208 (PKCS#8) and RFC 5280 (SubjectPublicKeyInfo):
+//  RSAPublicKey ::= SEQUENCE { modulus INTEGER, publicExponent INTEGER }
+//  RSAPrivateKey ::= SEQUENCE { version INTEGER (0), modulus, publicExponent, privateExponent, prime1, prime2,
+//                               exponent1 (d mod (p-1)), exponent2 (d mod (q-1)), coefficient (q^-1 mod p) }
+//  PrivateKeyInfo (PKCS#8) ::= SEQUENCE { version INTEGER (0), AlgorithmIdentifier, OCTET STRING (RSAPrivateKey) }
+//  SubjectPublicKeyInfo ::= SEQUENCE { AlgorithmIdentifier, BIT STRING (RSAPublicKey) }
+// PKCS#1 is RSA only. PKCS#8 and SubjectPublicKeyInfo say which algorithm the key is for, so work for any kind of key.
+// The BIT STRING starts with the number of unused bits at the end, always 0 here
+
+// Encodes a public key as a PKCS#1 RSAPublicKey
+func MarshalPKCS1PublicKey(pub *PublicKey) []byte {
+  return der_sequence(der_integer(pub.N), der_integer(big.NewInt(int64(pub.E))))
+}
+
+// Decodes a PKCS#1 RSAPublicKey
+func ParsePKCS1PublicKey(der []byte) (*PublicKey, error) {
+  value, err := der_parse_all(der, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+  members, err := value.members(der_integer_tag, der_integer_tag)
+  if err != nil {
+    return nil, err
+  }
+  numbers, err := der_integers(members)
+  if err != nil {
+    return nil, err
+  }
+  e, err := der_exponent(numbers[1])
+  if err != nil {
+    return nil, err
+  }
+  return &PublicKey{N: numbers[0], E: e}, nil
+}
+
+// Works out the CRT values the private key formats save, without storing them in the key like Precompute would
+func saved_crt_values(priv *PrivateKey) (dp *big.Int, dq *big.Int, qinv *big.Int, err error) {
+  one := big.NewInt(1)
+  if priv.P == nil || priv.Q == nil {
+    return nil, nil, nil, errors.New("rsa: can't save a key without its primes")
+  }
+  if priv.P.Cmp(one)<=0 || priv.Q.Cmp(one)<=0 {
+    return nil, nil, nil, errors.New("rsa: can't save a key with p or q less than 2")
+  }
+  if dp, dq, qinv = priv.crt_values(); qinv == nil {
+    return nil, nil, nil, errors.New("rsa: can't save a key where q has no inverse mod p")
+  }
   return
 }
 
-// Create random numbers until it finds a prime
-func create_random_prime(bits int) (prime *big.Int) {
-  for true {
-    prime = create_random_bignum(bits) // Create a random number
-    if big.ProbablyPrime(prime, 20) { // Do 20 rabin-miller tests to check if it's prime
-      return
+// Encodes a private key as a PKCS#1 RSAPrivateKey. It needs the primes, which keys made from just n, e and d lack
+func MarshalPKCS1PrivateKey(priv *PrivateKey) ([]byte, error) {
+  dp, dq, qinv, err := saved_crt_values(priv)
+  if err != nil {
+    return nil, err
+  }
+  numbers := []*big.Int{big.NewInt(0), priv.N, big.NewInt(int64(priv.E)), priv.D, priv.P, priv.Q, dp, dq, qinv}
+  members := make([][]byte, len(numbers))
+  for i, x := range numbers {
+    members[i] = der_integer(x)
+  }
+  return der_sequence(members...), nil
+}
+
+// Decodes a PKCS#1 RSAPrivateKey, and validates it
+func ParsePKCS1PrivateKey(der []byte) (*PrivateKey, error) {
+  value, err := der_parse_all(der, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+  tags := make([]byte, 9)
+  for i := range tags {
+    tags[i] = der_integer_tag
+  }
+  members, err := value.members(tags...)
+  if err != nil {
+    return nil, err // Including version 1 keys, which have more than two primes
+  }
+  numbers, err := der_integers(members)
+  if err != nil {
+    return nil, err
+  }
+  if numbers[0].Sign()!=0 {
+    return nil, errors.New("rsa: unsupported private key version")
+  }
+  e, err := der_exponent(numbers[2])
+  if err != nil {
+    return nil, err
+  }
+  priv := &PrivateKey{PublicKey: PublicKey{N: numbers[1], E: e}, D: numbers[3], P: numbers[4], Q: numbers[5],
+    Dp: numbers[6], Dq: numbers[7], Qinv: numbers[8]}
+  if err := priv.Validate(); err != nil {
+    return nil, err
+  }
+  return priv, nil
+}
+
+// Encodes a private key as PKCS#8
+func MarshalPKCS8PrivateKey(priv *PrivateKey) ([]byte, error) {
+  key, err := MarshalPKCS1PrivateKey(priv)
+  if err != nil {
+    return nil, err
+  }
+  return der_sequence(der_integer(big.NewInt(0)), rsa_algorithm, der_element(der_octet_string_tag, key)), nil
+}
+
+// Decodes a PKCS#8 private key, which must be RSA
+func ParsePKCS8PrivateKey(der []byte) (*PrivateKey, error) {
+  value, err := der_parse_all(der, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+  members, err := value.members(der_integer_tag, der_sequence_tag, der_octet_string_tag)
+  if err != nil {
+    return nil, err
+  }
+  if version, err := members[0].integer(); err != nil || version.Sign()!=0 {
+    return nil, errors.New("rsa: unsupported PKCS#8 version")
+  }
+  if !bytes.Equal(members[1].encoded(), rsa_algorithm) {
+    return nil, errors.New("rsa: not an RSA key")
+  }
+  return ParsePKCS1PrivateKey(members[2].contents)
+}
+
+// Encodes a public key as a SubjectPublicKeyInfo, the usual format for public keys (eg in certificates)
+func MarshalPKIXPublicKey(pub *PublicKey) []byte {
+  bits := append([]byte{0}, MarshalPKCS1PublicKey(pub)...) // No unused bits
+  return der_sequence(rsa_algorithm, der_element(der_bit_string_tag, bits))
+}
+
+// Decodes a SubjectPublicKeyInfo, which must be RSA
+func ParsePKIXPublicKey(der []byte) (*PublicKey, error) {
+  value, err := der_parse_all(der, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+  members, err := value.members(der_sequence_tag, der_bit_string_tag)
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(members[0].encoded(), rsa_algorithm) {
+    return nil, errors.New("rsa: not an RSA key")
+  }
+  bits := members[1].contents
+  if len(bits)==0 || bits[0]!=0 {
+    return nil, errors.New("asn1: bad BIT STRING")
+  }
+  return ParsePKCS1PublicKey(bits[1:])
+}
+
+// PEM labels for the formats
+const (
+  pem_pkcs1_private = "RSA PRIVATE KEY"
+  pem_pkcs1_public = "RSA PUBLIC KEY"
+  pem_pkcs8_private = "PRIVATE KEY"
+  pem_pkix_public = "PUBLIC KEY"
+)
+
+// Wraps DER in PEM
+func encode_pem(label string, der []byte) []byte {
+  return pem.EncodeToMemory(&pem.Block{Type: label, Bytes: der})
+}
+
+// Reads the first key from PEM, in any of the formats above. Returns the private key if it's one, and the public key
+func ParsePEM(data []byte) (priv *PrivateKey, pub *PublicKey, err error) {
+  block, _ := pem.Decode(data)
+  if block == nil {
+    return nil, nil, errors.New("rsa: no PEM found")
+  }
+  switch block.Type {
+  case pem_pkcs1_private:
+    priv, err = ParsePKCS1PrivateKey(block.Bytes)
+  case pem_pkcs8_private:
+    priv, err = ParsePKCS8PrivateKey(block.Bytes)
+  case pem_pkcs1_public:
+    pub, err = ParsePKCS1PublicKey(block.Bytes)
+  case pem_pkix_public:
+    pub, err = ParsePKIXPublicKey(block.Bytes)
+  case pem_encrypted_private:
+    err = errors.New("rsa: the key is encrypted, so needs a password")
+  default:
+    err = fmt.Errorf("rsa: unknown PEM type %s", block.Type)
+  }
+  if priv != nil {
+    pub = &priv.PublicKey
+  }
+  return
+}
+
+// OpenSSH has its own formats, built from the SSH wire types (RFC 4251 section 5):
+//  uint32 - 4 bytes, big-endian
+//  string - a uint32 length, then that many bytes
+//  mpint - a string holding a big-endian two's complement number, with a zero byte in front if the top bit is set
+// A public key is string "ssh-rsa", mpint e, mpint n (RFC 4253 section 6.6), which authorized_keys has as one line:
+//  [options] ssh-rsa <base64 of the public key> comment
+// Reference: http://man.openbsd.org/sshd.8#AUTHORIZED_KEYS_FILE_FORMAT
+
+// Appends SSH wire types
+func ssh_string(b []byte, s []byte) []byte {
+  b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+  return append(b, s...)
+}
+func ssh_mpint(b []byte, x *big.Int) []byte {
+  contents := x.Bytes()
+  if len(contents)>0 && contents[0]&0x80!=0 {
+    contents = append([]byte{0}, contents...)
+  }
+  return ssh_string(b, contents)
+}
+
+// Reads SSH wire types one after another. The first problem sticks in err, so it only needs checking at the end
+type ssh_reader struct {
+  data []byte
+  err error
+}
+func (r *ssh_reader) uint32() uint32 {
+  if len(r.data)<4 {
+    r.err = errors.New("ssh: data cut short")
+    return 0
+  }
+  n := binary.BigEndian.Uint32(r.data)
+  r.data = r.data[4:]
+  return n
+}
+func (r *ssh_reader) string() []byte {
+  n := r.uint32()
+  if r.err != nil || uint32(len(r.data))<n {
+    r.err = errors.New("ssh: data cut short")
+    return nil
+  }
+  s := r.data[:n]
+  r.data = r.data[n:]
+  return s
+}
+func (r *ssh_reader) mpint() *big.Int {
+  s := r.string()
+  if len(s)>0 && (s[0]&0x80!=0 || (s[0]==0 && (len(s)==1 || s[1]&0x80==0))) { // Negative, or an extra zero byte
+    r.err = errors.New("ssh: bad mpint")
+  }
+  return new(big.Int).SetBytes(s)
+}
+
+// Encodes a public key in SSH's wire format
+func ssh_public_key(pub *PublicKey) []byte {
+  b := ssh_string(nil, []byte("ssh-rsa"))
+  b = ssh_mpint(b, big.NewInt(int64(pub.E)))
+  return ssh_mpint(b, pub.N)
+}
+
+// Reads a public key in SSH's wire format
+func parse_ssh_public_key(data []byte) (*PublicKey, error) {
+  r := &ssh_reader{data: data}
+  kind := string(r.string())
+  e, n := r.mpint(), r.mpint()
+  if r.err != nil {
+    return nil, r.err
+  }
+  if kind!="ssh-rsa" || len(r.data)!=0 {
+    return nil, errors.New("ssh: not an RSA public key")
+  }
+  exponent, err := der_exponent(e)
+  if err != nil {
+    return nil, err
+  }
+  return &PublicKey{N: n, E: exponent}, nil
+}
+
+// Makes an authorized_keys line for a public key
+func MarshalAuthorizedKey(pub *PublicKey, comment string) []byte {
+  line := "ssh-rsa " + base64.StdEncoding.EncodeToString(ssh_public_key(pub))
+  if comment!="" {
+    line += " " + comment
+  }
+  return []byte(line + "\n")
+}
+
+// Reads an authorized_keys line. The fields can be split by any spaces or tabs, and any options before the key type
+// (eg from="10.0.0.1",no-pty) are skipped
+func ParseAuthorizedKey(line []byte) (pub *PublicKey, comment string, err error) {
+  fields := strings.Fields(string(line))
+  for len(fields)>0 && fields[0]!="ssh-rsa" {
+    fields = fields[1:]
+  }
+  if len(fields)<2 {
+    return nil, "", errors.New("ssh: not an ssh-rsa authorized_keys line")
+  }
+  data, err := base64.StdEncoding.DecodeString(fields[1])
+  if err != nil {
+    return nil, "", err
+  }
+  if pub, err = parse_ssh_public_key(data); err != nil {
+    return nil, "", err
+  }
+  return pub, strings.Join(fields[2:], " "), nil
+}
+
+// OpenSSH's own private key format (PROTOCOL.key in OpenSSH's source), used by ssh-keygen since OpenSSH 7.8:
+//  "openssh-key-v1" and a zero byte
+//  string cipher, string kdf, string kdf options - "none", "none", "" when not encrypted
+//  uint32 number of keys (1), string public key
+//  string private part:
+//    uint32 check, uint32 check - the same random number twice, so decrypting with the wrong password shows up
+//    string "ssh-rsa", mpint n, e, d, q^-1 mod p, p, q, string comment
+//    padding 1, 2, 3... to a multiple of the cipher's block size (8 with no cipher)
+// It's PEM-like, but between BEGIN/END OPENSSH PRIVATE KEY lines of 70 characters.
+// Reference: http://cvsweb.openbsd.org/src/usr.bin/ssh/PROTOCOL.key
+const openssh_magic = "openssh-key-v1\x00"
+const openssh_label = "OPENSSH PRIVATE KEY"
+
+// Encodes a private key in OpenSSH's format, unencrypted. The check number comes from random
+func MarshalOpenSSHPrivateKey(random io.Reader, priv *PrivateKey, comment string) ([]byte, error) {
+  _, _, qinv, err := saved_crt_values(priv)
+  if err != nil {
+    return nil, err
+  }
+  check := make([]byte, 4)
+  if _, err := io.ReadFull(random, check); err != nil {
+    return nil, err
+  }
+  private := append(check, check...)
+  private = ssh_string(private, []byte("ssh-rsa"))
+  for _, x := range []*big.Int{priv.N, big.NewInt(int64(priv.E)), priv.D, qinv, priv.P, priv.Q} {
+    private = ssh_mpint(private, x)
+  }
+  private = ssh_string(private, []byte(comment))
+  for i:=byte(1); len(private)%8!=0; i++ {
+    private = append(private, i)
+  }
+
+  data := []byte(openssh_magic)
+  data = ssh_string(data, []byte("none"))
+  data = ssh_string(data, []byte("none"))
+  data = ssh_string(data, nil)
+  data = binary.BigEndian.AppendUint32(data, 1)
+  data = ssh_string(data, ssh_public_key(&priv.PublicKey))
+  data = ssh_string(data, private)
+
+  encoded := base64.StdEncoding.EncodeToString(data)
+  var out strings.Builder
+  out.WriteString("-----BEGIN " + openssh_label + "-----\n")
+  for len(encoded)>70 {
+    out.WriteString(encoded[:70] + "\n")
+    encoded = encoded[70:]
+  }
+  out.WriteString(encoded + "\n-----END " + openssh_label + "-----\n")
+  return []byte(out.String()), nil
+}
+
+// Reads an unencrypted OpenSSH private key, and validates it
+func ParseOpenSSHPrivateKey(data []byte) (priv *PrivateKey, comment string, err error) {
+  block, _ := pem.Decode(data)
+  if block == nil || block.Type!=openssh_label || !bytes.HasPrefix(block.Bytes, []byte(openssh_magic)) {
+    return nil, "", errors.New("ssh: not an OpenSSH private key")
+  }
+  r := &ssh_reader{data: block.Bytes[len(openssh_magic):]}
+  cipher, kdf, _, keys := string(r.string()), string(r.string()), r.string(), r.uint32()
+  public, private := r.string(), r.string()
+  if r.err != nil {
+    return nil, "", r.err
+  }
+  if cipher!="none" || kdf!="none" {
+    return nil, "", errors.New("ssh: encrypted keys aren't supported")
+  }
+  if keys!=1 || len(r.data)!=0 {
+    return nil, "", errors.New("ssh: expected exactly one key")
+  }
+  pub, err := parse_ssh_public_key(public)
+  if err != nil {
+    return nil, "", err
+  }
+
+  r = &ssh_reader{data: private}
+  check1, check2, kind := r.uint32(), r.uint32(), string(r.string())
+  n, e, d, qinv, p, q := r.mpint(), r.mpint(), r.mpint(), r.mpint(), r.mpint(), r.mpint()
+  comment = string(r.string())
+  if r.err != nil {
+    return nil, "", r.err
+  }
+  if check1!=check2 || kind!="ssh-rsa" {
+    return nil, "", errors.New("ssh: bad private key")
+  }
+  for i, b := range r.data {
+    if b!=byte(i+1) {
+      return nil, "", errors.New("ssh: bad padding")
     }
   }
-  return // This is just here to keep the compiler happy
+  if n.Cmp(pub.N)!=0 || e.Cmp(big.NewInt(int64(pub.E)))!=0 {
+    return nil, "", errors.New("ssh: the public and private keys don't match")
+  }
+  // Validate before working out the CRT values, which would divide by zero or panic for crafted p and q, eg 1 or p = q
+  priv = &PrivateKey{PublicKey: *pub, D: d, P: p, Q: q}
+  if err = priv.Validate(); err != nil {
+    return nil, "", err
+  }
+  priv.Precompute()
+  if priv.Qinv==nil || priv.Qinv.Cmp(qinv)!=0 {
+    return nil, "", errors.New("ssh: bad private key")
+  }
+  return priv, comment, nil
+}
+
+// JSON Web Keys (RFC 7517, with the RSA members from RFC 7518 section 6.3) are JSON objects with the numbers
+// big-endian in base64url without padding, eg:
+//  {"kty":"RSA","n":"0vx7agoebG...","e":"AQAB"}
+// and for private keys d, p, q, dp (d mod (p-1)), dq (d mod (q-1)) and qi (q^-1 mod p) as well
+type jwk struct {
+  Kty string `json:"kty"`
+  N string `json:"n"`
+  E string `json:"e"`
+  D string `json:"d,omitempty"`
+  P string `json:"p,omitempty"`
+  Q string `json:"q,omitempty"`
+  Dp string `json:"dp,omitempty"`
+  Dq string `json:"dq,omitempty"`
+  Qi string `json:"qi,omitempty"`
+}
+
+// Encodes a number for a JWK
+func jwk_number(x *big.Int) string {
+  return base64.RawURLEncoding.EncodeToString(x.Bytes())
+}
+
+// Encodes a public key as a JWK
+func MarshalPublicJWK(pub *PublicKey) []byte {
+  data, _ := json.Marshal(jwk{Kty: "RSA", N: jwk_number(pub.N), E: jwk_number(big.NewInt(int64(pub.E)))})
+  return data
+}
+
+// Encodes a private key as a JWK
+func MarshalPrivateJWK(priv *PrivateKey) ([]byte, error) {
+  dp, dq, qinv, err := saved_crt_values(priv)
+  if err != nil {
+    return nil, err
+  }
+  return json.Marshal(jwk{Kty: "RSA", N: jwk_number(priv.N), E: jwk_number(big.NewInt(int64(priv.E))),
+    D: jwk_number(priv.D), P: jwk_number(priv.P), Q: jwk_number(priv.Q),
+    Dp: jwk_number(dp), Dq: jwk_number(dq), Qi: jwk_number(qinv)})
+}
+
+// Reads a JWK. Returns the private key if it's one (which must have all its members), and the public key
+func ParseJWK(data []byte) (priv *PrivateKey, pub *PublicKey, err error) {
+  var key jwk
+  if err = json.Unmarshal(data, &key); err != nil {
+    return nil, nil, err
+  }
+  if key.Kty!="RSA" {
+    return nil, nil, errors.New("jwk: not an RSA key")
+  }
+  fields := []string{key.N, key.E}
+  if key.D!="" {
+    fields = append(fields, key.D, key.P, key.Q, key.Dp, key.Dq, key.Qi)
+  }
+  numbers := make([]*big.Int, len(fields))
+  for i, field := range fields {
+    b, err := base64.RawURLEncoding.DecodeString(field)
+    if err != nil || len(b)==0 {
+      return nil, nil, errors.New("jwk: missing or bad number")
+    }
+    numbers[i] = new(big.Int).SetBytes(b)
+  }
+  e, err := der_exponent(numbers[1])
+  if err != nil {
+    return nil, nil, err
+  }
+  pub = &PublicKey{N: numbers[0], E: e}
+  if key.D=="" {
+    return nil, pub, nil
+  }
+  priv = &PrivateKey{PublicKey: *pub, D: numbers[2], P: numbers[3], Q: numbers[4], Dp: numbers[5], Dq: numbers[6], Qinv: numbers[7]}
+  if err = priv.Validate(); err != nil {
+    return nil, nil, err
+  }
+  return priv, &priv.PublicKey, nil
+}
+
+// Reads a key in any of the formats: PEM (including OpenSSH's), an authorized_keys line, or a JWK
+func ParseKey(data []byte) (priv *PrivateKey, pub *PublicKey, err error) {
+  text := bytes.TrimSpace(data)
+  switch {
+  case bytes.HasPrefix(text, []byte("{")):
+    return ParseJWK(data)
+  case bytes.HasPrefix(text, []byte("-----BEGIN "+openssh_label)):
+    priv, _, err = ParseOpenSSHPrivateKey(data)
+    if err != nil {
+      return nil, nil, err
+    }
+    return priv, &priv.PublicKey, nil
+  case !bytes.HasPrefix(text, []byte("-----BEGIN ")) && slices.Contains(strings.Fields(string(text)), "ssh-rsa"):
+    pub, _, err = ParseAuthorizedKey(data) // The key type can come after options, so look for it anywhere in the line
+    return nil, pub, err
+  }
+  return ParsePEM(data)
+}
+
+// PBKDF2 (RFC 8018 5.2) turns a password into a key. It's deliberately slow, to make guessing passwords slow: each
+// block of the key is an HMAC of the salt and the block number, then HMAC'd again and again, 'iterations' times,
+// with every result xor'd together:
+//  U1 = HMAC(password, salt || block number), U2 = HMAC(password, U1), ...
+//  block = U1 xor U2 xor ... xor U(iterations)
+// The salt makes the same password give different keys, so guesses can't be worked out once for everybody
+func pbkdf2(hash crypto.Hash, password []byte, salt []byte, iterations int, length int) []byte {
+  var key []byte
+  for block:=uint32(1); len(key)<length; block++ {
+    mac := hmac.New(hash.New, password)
+    mac.Write(salt)
+    mac.Write(binary.BigEndian.AppendUint32(nil, block))
+    u := mac.Sum(nil)
+    t := append([]byte{}, u...)
+    for i:=1;i<iterations;i++ {
+      mac.Reset()
+      mac.Write(u)
+      u = mac.Sum(u[:0])
+      for j := range t {
+        t[j] ^= u[j]
+      }
+    }
+    key = append(key, t...)
+  }
+  return key[:length]
+}
+
+// An encrypted PKCS#8 key (RFC 5958, with PBES2 from RFC 8018) is the PKCS#8 key encrypted with AES-CBC, with the
+// AES key made from the password by PBKDF2, and the details of both:
+//  EncryptedPrivateKeyInfo ::= SEQUENCE { SEQUENCE { pbes2 OID, PBES2-params }, OCTET STRING (encrypted key) }
+//  PBES2-params ::= SEQUENCE { SEQUENCE { pbkdf2 OID, PBKDF2-params }, SEQUENCE { aes-CBC OID, OCTET STRING (IV) } }
+//  PBKDF2-params ::= SEQUENCE { salt OCTET STRING, iterations INTEGER, key length INTEGER OPTIONAL,
+//                               SEQUENCE { hmac OID, NULL } DEFAULT hmacWithSHA1 }
+// The key is padded to a multiple of 16 bytes first with PKCS#7: n bytes of n, 1 to 16 of them.
+// There's nothing to say whether the password was right, but a wrong one gives garbage, which almost certainly has
+// bad padding, and if not then isn't a PKCS#8 key or doesn't validate
+var (
+  oid_pbes2 = der_oid(1, 2, 840, 113549, 1, 5, 13)
+  oid_pbkdf2 = der_oid(1, 2, 840, 113549, 1, 5, 12)
+  oid_hmac_sha1 = der_oid(1, 2, 840, 113549, 2, 7)
+  oid_hmac_sha256 = der_oid(1, 2, 840, 113549, 2, 9)
+  oid_hmac_sha512 = der_oid(1, 2, 840, 113549, 2, 11)
+  oid_aes128_cbc = der_oid(2, 16, 840, 1, 101, 3, 4, 1, 2)
+  oid_aes192_cbc = der_oid(2, 16, 840, 1, 101, 3, 4, 1, 22)
+  oid_aes256_cbc = der_oid(2, 16, 840, 1, 101, 3, 4, 1, 42)
+)
+
+// The hashes PBKDF2 can use, and AES key sizes, by their encoded OIDs
+var pbkdf2_hashes = map[string]crypto.Hash{
+  string(oid_hmac_sha1): crypto.SHA1,
+  string(oid_hmac_sha256): crypto.SHA256,
+  string(oid_hmac_sha512): crypto.SHA512,
+}
+var pbes2_key_sizes = map[string]int{
+  string(oid_aes128_cbc): 16,
+  string(oid_aes192_cbc): 24,
+  string(oid_aes256_cbc): 32,
+}
+
+// PBKDF2 iterations for new keys. More is slower for attackers guessing passwords, but also for us. OWASP suggests
+// 600000 for PBKDF2 with SHA-256 (OpenSSL still defaults to 2048)
+const DefaultIterations = 600000
+
+// The most PBKDF2 iterations we'll do, several seconds' worth. A key file can ask for up to 2^31-1, which takes hundreds
+// of times longer, so without a limit anyone who can hand us a key file could keep us busy for as long as they liked
+const MaxIterations = 10000000
+
+const pem_encrypted_private = "ENCRYPTED PRIVATE KEY"
+
+// Encrypts a private key as PKCS#8 with a password, using AES-256-CBC and PBKDF2 with SHA-256. The salt and IV come
+// from random
+func MarshalEncryptedPKCS8PrivateKey(random io.Reader, priv *PrivateKey, password []byte, iterations int) ([]byte, error) {
+  key, err := MarshalPKCS8PrivateKey(priv)
+  if err != nil {
+    return nil, err
+  }
+  if iterations<1 || iterations>MaxIterations {
+    return nil, fmt.Errorf("pkcs8: iterations must be from 1 to %d, got %d", MaxIterations, iterations)
+  }
+  salt, iv := make([]byte, 16), make([]byte, 16)
+  if _, err := io.ReadFull(random, salt); err != nil {
+    return nil, err
+  }
+  if _, err := io.ReadFull(random, iv); err != nil {
+    return nil, err
+  }
+  aes_keys, _ := key_schedule(pbkdf2(crypto.SHA256, password, salt, iterations, 32), 0, 32)
+  padding := 16-len(key)%16
+  encrypted := cbc_encrypt(append(key, bytes.Repeat([]byte{byte(padding)}, padding)...), aes_keys, iv)
+
+  kdf := der_sequence(oid_pbkdf2, der_sequence(der_element(der_octet_string_tag, salt),
+    der_integer(big.NewInt(int64(iterations))), der_sequence(oid_hmac_sha256, der_element(der_null_tag, nil))))
+  cipher := der_sequence(oid_aes256_cbc, der_element(der_octet_string_tag, iv))
+  algorithm := der_sequence(oid_pbes2, der_sequence(kdf, cipher))
+  return der_sequence(algorithm, der_element(der_octet_string_tag, encrypted)), nil
+}
+
+// Decrypts a PKCS#8 key encrypted with PBES2 (PBKDF2 with SHA-1, SHA-256 or SHA-512, and AES-CBC), and validates it
+func ParseEncryptedPKCS8PrivateKey(der []byte, password []byte) (*PrivateKey, error) {
+  unsupported := errors.New("pkcs8: unsupported encryption, only PBES2 with PBKDF2 and AES-CBC")
+  value, err := der_parse_all(der, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+  members, err := value.members(der_sequence_tag, der_octet_string_tag)
+  if err != nil {
+    return nil, err
+  }
+  algorithm, err := members[0].members(der_oid_tag, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(algorithm[0].encoded(), oid_pbes2) {
+    return nil, unsupported
+  }
+  params, err := algorithm[1].members(der_sequence_tag, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+
+  // PBKDF2's parameters, with the optional key length and hash
+  kdf, err := params[0].members(der_oid_tag, der_sequence_tag)
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(kdf[0].encoded(), oid_pbkdf2) {
+    return nil, unsupported
+  }
+  kdf_params, err := kdf[1].all_members()
+  if err != nil {
+    return nil, err
+  }
+  if len(kdf_params)<2 || kdf_params[0].tag!=der_octet_string_tag {
+    return nil, errors.New("pkcs8: bad PBKDF2 parameters")
+  }
+  salt := kdf_params[0].contents
+  iterations, err := kdf_params[1].integer()
+  if err != nil || iterations.Sign()<=0 {
+    return nil, errors.New("pkcs8: bad PBKDF2 iterations")
+  }
+  if iterations.Cmp(big.NewInt(MaxIterations))>0 {
+    return nil, fmt.Errorf("pkcs8: the key asks for %s PBKDF2 iterations, more than the limit of %d", iterations,
+      MaxIterations)
+  }
+  key_length, hash := 0, crypto.SHA1
+  for _, param := range kdf_params[2:] {
+    if param.tag==der_integer_tag && key_length==0 {
+      length, err := param.integer()
+      if err != nil || length.BitLen()>8 {
+        return nil, errors.New("pkcs8: bad PBKDF2 key length")
+      }
+      key_length = int(length.Int64())
+    } else if param.tag==der_sequence_tag {
+      prf, err := param.all_members()
+      if err != nil || len(prf)==0 {
+        return nil, errors.New("pkcs8: bad PBKDF2 hash")
+      }
+      var ok bool
+      if hash, ok = pbkdf2_hashes[string(prf[0].encoded())]; !ok {
+        return nil, unsupported
+      }
+    } else {
+      return nil, errors.New("pkcs8: bad PBKDF2 parameters")
+    }
+  }
+
+  // AES's parameters
+  cipher, err := params[1].members(der_oid_tag, der_octet_string_tag)
+  if err != nil {
+    return nil, err
+  }
+  key_size, ok := pbes2_key_sizes[string(cipher[0].encoded())]
+  if !ok {
+    return nil, unsupported
+  }
+  iv := cipher[1].contents
+  if len(iv)!=16 || (key_length!=0 && key_length!=key_size) {
+    return nil, errors.New("pkcs8: bad AES parameters")
+  }
+
+  // Decrypt, and check it came out as a key
+  wrong := errors.New("pkcs8: wrong password, or the key is corrupt")
+  encrypted := members[1].contents
+  if len(encrypted)==0 || len(encrypted)%16!=0 {
+    return nil, wrong
+  }
+  aes_keys, _ := key_schedule(pbkdf2(hash, password, salt, int(iterations.Int64()), key_size), 0, key_size)
+  key := cbc_decrypt(encrypted, aes_keys, iv)
+  padding := int(key[len(key)-1])
+  if padding<1 || padding>16 || !bytes.Equal(key[len(key)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+    return nil, wrong
+  }
+  priv, err := ParsePKCS8PrivateKey(key[:len(key)-padding])
+  if err != nil {
+    return nil, wrong
+  }
+  return priv, nil
+}
+
+// Reads a private key from an ENCRYPTED PRIVATE KEY PEM block
+func ParseEncryptedPEM(data []byte, password []byte) (*PrivateKey, error) {
+  block, _ := pem.Decode(data)
+  if block == nil || block.Type!=pem_encrypted_private {
+    return nil, errors.New("rsa: no encrypted private key found")
+  }
+  return ParseEncryptedPKCS8PrivateKey(block.Bytes, password)
+}
+
+// Hashes a message with the given hash
+func hash_message(hash crypto.Hash, msg []byte) []byte {
+  h := hash.New()
+  h.Write(msg)
+  return h.Sum(nil)
+}
+
+// Reads a file of test values, in lines of "name: hex", skipping blank lines and # comments
+func read_fixture(file string) (values map[string][]byte, err error) {
+  data, err := os.ReadFile(file)
+  if err != nil {
+    return nil, err
+  }
+  values = make(map[string][]byte)
+  for _, line := range strings.Split(string(data), "\n") {
+    line = strings.TrimSpace(line)
+    if line=="" || line[0]=='#' {
+      continue
+    }
+    colon := strings.Index(line, ":")
+    if colon<0 {
+      return nil, fmt.Errorf("%s: expected name: hex, got %s", file, line)
+    }
+    value := strings.TrimSpace(line[colon+1:])
+    if len(value)%2==1 {
+      value = "0" + value
+    }
+    if values[line[:colon]], err = hex.DecodeString(value); err != nil {
+      return nil, fmt.Errorf("%s: %s", file, err)
+    }
+  }
+  return
+}
+
+// Makes a private key from the n, e, d, p and q in a fixture. Some fixtures don't have p and q
+func fixture_key(values map[string][]byte) *PrivateKey {
+  priv := &PrivateKey{PublicKey: PublicKey{N: os2ip(values["n"]), E: int(os2ip(values["e"]).Int64())}, D: os2ip(values["d"])}
+  if values["p"]!=nil && values["q"]!=nil {
+    priv.P, priv.Q = os2ip(values["p"]), os2ip(values["q"])
+    priv.Precompute()
+  }
+  return priv
+}
+
+// The same key as the standard library's crypto/rsa type, for checking against it
+func (priv *PrivateKey) standard() *rsa.PrivateKey {
+  key := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: priv.N, E: priv.E}, D: priv.D, Primes: []*big.Int{priv.P, priv.Q}}
+  key.Precompute()
+  return key
+}
+
+// Times decrypting with plain c^d mod n against the Chinese remainder theorem, with and without the fault check
+func crt_benchmark(bits int, rounds int) error {
+  priv, err := GenerateKey(rand.Reader, bits)
+  if err != nil {
+    return err
+  }
+  c, err := create_random_bignum(rand.Reader, bits-1) // Less than n
+  if err != nil {
+    return err
+  }
+  timing := func(name string, decrypt func()) time.Duration {
+    start := time.Now()
+    for i:=0;i<rounds;i++ {
+      decrypt()
+    }
+    taken := time.Since(start)/time.Duration(rounds)
+    fmt.Printf("%-26s %v per decryption\r\n", name, taken)
+    return taken
+  }
+  fmt.Printf("%d bit key, %d decryptions each\r\n", bits, rounds)
+  plain := timing("c^d mod n:", func() { priv.decrypt_plain(c) })
+  crt := timing("CRT:", func() { priv.crt(c, false) })
+  checked := timing("CRT with the fault check:", func() { priv.decrypt_unblinded(c) })
+  blinded := timing("And blinding:", func() { priv.decrypt(c) })
+  fmt.Printf("CRT is %.1f times quicker, %.1f with the fault check, %.1f with blinding as well\r\n",
+    float64(plain)/float64(crt), float64(plain)/float64(checked), float64(plain)/float64(blinded))
+  return nil
+}
+
+// Shows the timing leak that blinding stops. Decrypts ciphertexts of random sizes, from 16 bits up to the size of
+// the key, timing each with and without blinding, then correlates the times against the sizes. It does it two ways:
+// big.Int's Exp, which works on numbers the size of n whatever the input, and textbook square-and-multiply
+// (mod_exp_traced), which multiplies by c for every set bit of d, so is quicker for a small c. Without blinding the
+// textbook one's times give away the size of c; with it, every decryption works on a random number the size of n,
+// so there's next to no correlation. The real attacks use subtler differences than size, but it's the same leak.
+// Each decryption is timed timing_repeats times and the quickest taken, as an attacker would, to filter out noise
+const timing_repeats = 5
+
+func blinding_experiment(bits int, samples int) error {
+  priv, err := GenerateKey(rand.Reader, bits)
+  if err != nil {
+    return err
+  }
+  textbook := func(c *big.Int) (*big.Int, error) { return mod_exp_traced(c, priv.D, priv.N, nil), nil }
+  names := []string{"Exp:", "Exp, blinded:", "Square-and-multiply:", "Square-and-multiply, blinded:"}
+  decrypts := []func(c *big.Int) (*big.Int, error){
+    priv.decrypt_unblinded,
+    priv.decrypt,
+    textbook,
+    func(c *big.Int) (*big.Int, error) { return priv.blinded(c, textbook) },
+  }
+  sizes := make([]float64, samples)
+  times := make([][]float64, len(decrypts))
+  totals := make([]time.Duration, len(decrypts))
+  for i := range times {
+    times[i] = make([]float64, samples)
+  }
+  for i:=-samples/10;i<samples;i++ { // The first tenth warms up the CPU and isn't counted
+    r, err := rand.Int(rand.Reader, big.NewInt(int64(bits-16)))
+    if err != nil {
+      return err
+    }
+    size := 16 + int(r.Int64())
+    c, err := create_random_bignum(rand.Reader, size) // Less than n
+    if err != nil {
+      return err
+    }
+    for j, decrypt := range decrypts { // Take turns, so the CPU speeding up or slowing down affects them all the same
+      var taken time.Duration
+      for k:=0;k<timing_repeats;k++ {
+        start := time.Now()
+        decrypt(c)
+        if t := time.Since(start); k==0 || t<taken {
+          taken = t
+        }
+      }
+      if i>=0 {
+        times[j][i] = float64(taken)
+        totals[j] += taken
+      }
+    }
+    if i>=0 {
+      sizes[i] = float64(size)
+    }
+  }
+  fmt.Printf("%d bit key, %d ciphertexts of 16 to %d bits, the quickest of %d tries each\r\n", bits, samples, bits-1, timing_repeats)
+  for j, name := range names {
+    fmt.Printf("%-30s %10v per decryption, correlation of time with ciphertext size %6.3f\r\n", name,
+      totals[j]/time.Duration(samples), correlation(sizes, times[j]))
+  }
+  return nil
 }
 
 // Test the RSA implementation
+// Also has some command line tools:
+//  go_rsa bench [bits] - Time decrypting with and without the Chinese remainder theorem (default 2048 bits)
+//  go_rsa timing [bits [samples]] - Show how decryption times leak the ciphertext, and how blinding stops it
+//  (default 1024 bits and 200 samples)
+//  go_rsa genkey [bits [pkcs1|pkcs8|ssh|jwk [password]]] - Make a key (default 2048 bits) and write it and its public
+//  key: as PEM in PKCS#1, or PKCS#8 and SubjectPublicKeyInfo (the default), or OpenSSH's private key format and an
+//  authorized_keys line, or JSON Web Keys. With a password, PKCS#8 keys are encrypted. Anyone can see command lines
+//  (eg with ps), so that's only for trying it out
+//  go_rsa showkey file [password] - Show the numbers in a key file, in any of those formats
 func main() {
+  if len(os.Args)>1 && len(os.Args)<=3 && os.Args[1]=="bench" {
+    bits := 2048
+    var err error
+    if len(os.Args)==3 {
+      bits, err = strconv.Atoi(os.Args[2])
+    }
+    if err == nil {
+      err = crt_benchmark(bits, 100)
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    return
+  }
+  if len(os.Args)>1 && len(os.Args)<=4 && os.Args[1]=="timing" {
+    bits, samples := 1024, 200
+    var err error
+    if len(os.Args)>=3 {
+      bits, err = strconv.Atoi(os.Args[2])
+    }
+    if err == nil && len(os.Args)==4 {
+      samples, err = strconv.Atoi(os.Args[3])
+    }
+    if err == nil && (bits<64 || samples<2) {
+      err = errors.New("Need at least 64 bits and 2 samples")
+    }
+    if err == nil {
+      err = blinding_experiment(bits, samples)
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    return
+  }
+  if len(os.Args)>1 && len(os.Args)<=5 && os.Args[1]=="genkey" {
+    bits, format, password := 2048, "pkcs8", ""
+    var err error
+    if len(os.Args)>=3 {
+      bits, err = strconv.Atoi(os.Args[2])
+    }
+    if len(os.Args)>=4 {
+      format = os.Args[3]
+    }
+    if len(os.Args)==5 {
+      password = os.Args[4]
+    }
+    if password!="" && format!="pkcs8" {
+      err = errors.New("Only PKCS#8 keys can be encrypted")
+    }
+    var priv *PrivateKey
+    if err == nil {
+      priv, err = GenerateKey(rand.Reader, bits)
+    }
+    var private, public []byte
+    if err == nil {
+      switch format {
+      case "pkcs1":
+        private, err = MarshalPKCS1PrivateKey(priv)
+        private, public = encode_pem(pem_pkcs1_private, private), encode_pem(pem_pkcs1_public, MarshalPKCS1PublicKey(&priv.PublicKey))
+      case "pkcs8":
+        label := pem_pkcs8_private
+        if password=="" {
+          private, err = MarshalPKCS8PrivateKey(priv)
+        } else {
+          private, err = MarshalEncryptedPKCS8PrivateKey(rand.Reader, priv, []byte(password), DefaultIterations)
+          label = pem_encrypted_private
+        }
+        private, public = encode_pem(label, private), encode_pem(pem_pkix_public, MarshalPKIXPublicKey(&priv.PublicKey))
+      case "ssh":
+        private, err = MarshalOpenSSHPrivateKey(rand.Reader, priv, "")
+        public = MarshalAuthorizedKey(&priv.PublicKey, "")
+      case "jwk":
+        private, err = MarshalPrivateJWK(priv)
+        private, public = append(private, '\n'), append(MarshalPublicJWK(&priv.PublicKey), '\n')
+      default:
+        err = errors.New("Bad arguments, see the comment above main() for usage")
+      }
+    }
+    if err == nil {
+      fmt.Printf("%s%s", private, public)
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    return
+  }
+  if (len(os.Args)==3 || len(os.Args)==4) && os.Args[1]=="showkey" {
+    data, err := os.ReadFile(os.Args[2])
+    var priv *PrivateKey
+    var pub *PublicKey
+    if err == nil && len(os.Args)==4 {
+      if priv, err = ParseEncryptedPEM(data, []byte(os.Args[3])); err == nil {
+        pub = &priv.PublicKey
+      }
+    } else if err == nil {
+      priv, pub, err = ParseKey(data)
+    }
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      os.Exit(1)
+    }
+    fmt.Printf("%d bit key\r\n", pub.N.BitLen())
+    fmt.Printf("Public key (n):\r\n %x\r\n", pub.N)
+    fmt.Printf("Exponent (e):\r\n %x\r\n", pub.E)
+    if priv != nil {
+      fmt.Printf("Secret key (d):\r\n %x\r\n", priv.D)
+      fmt.Printf("Prime p:\r\n %x\r\n", priv.P)
+      fmt.Printf("Prime q:\r\n %x\r\n", priv.Q)
+    }
+    return
+  }
+
   println("Test RSA crypto")
-  rand.Seed(time.Nanoseconds()) // Initialise the random generator
 
-  // Generate P and Q, two big prime numbers
+  // Generate the key: P and Q, two big prime numbers, n = p*q (the public key), e, and the private exponent d,
+  // which is the modular multiplicative inverse of e mod lambda(n)
   println("Generating primes...");
-  p := create_random_prime(512)
-  q := create_random_prime(512)
-  fmt.Printf("Prime p:\r\n %x\r\n", p)
-  fmt.Printf("Prime q:\r\n %x\r\n", q)
-  
-  // Make n (the public key) now: n=p*q
-  n := new(big.Int).Mul(p, q)
-  fmt.Printf("Public key (n):\r\n %x\r\n", n)
-  
-  // Public exponent (always 0x10001)
-  e := big.NewInt(0x10001)
-  fmt.Printf("Exponent (e):\r\n %x\r\n", e)
-
-  // Create phi: (p-1)*(q-1)
-  one := big.NewInt(1)
-  p_minus_1 := new(big.Int).Sub(p, one)
-  q_minus_1 := new(big.Int).Sub(q, one)
-  phi := new(big.Int).Mul(p_minus_1, q_minus_1)
-  
-  // Create the private key - it is the modular multiplicative inverse of e mod phi
-  d := new(big.Int).ModInverse(e, phi)
-  fmt.Printf("Secret key (d):\r\n %x\r\n", d)
+  priv, err := GenerateKey(rand.Reader, 1024)
+  if err != nil {
+    fmt.Printf("%s\r\n", err)
+    return
+  }
+  fmt.Printf("Prime p:\r\n %x\r\n", priv.P)
+  fmt.Printf("Prime q:\r\n %x\r\n", priv.Q)
+  fmt.Printf("Public key (n):\r\n %x\r\n", priv.N)
+  fmt.Printf("Exponent (e):\r\n %x\r\n", priv.E)
+  fmt.Printf("Secret key (d):\r\n %x\r\n", priv.D)
+  fmt.Printf("Validate: %v\r\n", priv.Validate())
 
   // Create a message randomly
-  m := create_random_bignum(512)
+  m, _ := create_random_bignum(rand.Reader, 512)
   fmt.Printf("Message (m):\r\n %x\r\n", m)
   
   // Encrypt it: c = m^e mod n
-  c := new(big.Int).Exp(m, e, n)
+  c := priv.encrypt(m)
   fmt.Printf("Crypto-text (c):\r\n %x\r\n", c)
   
   // Decrypt it: m = c^d mod n
-  a := new(big.Int).Exp(c, d, n)
+  a, err := priv.decrypt(c)
+  if err != nil {
+    fmt.Printf("%s\r\n", err)
+    return
+  }
   fmt.Printf("Message (c):\r\n %x\r\n", a)
+
+  println("\r\nTest the Bellcore attack on CRT")
+  // Sign with a glitch: s = m^d is right mod q, but wrong mod p. So s^e - m is a multiple of q but not of p
+  glitched := priv.crt(m, true)
+  factor := new(big.Int).Sub(priv.encrypt(glitched), m)
+  factor.GCD(nil, nil, factor, priv.N)
+  fmt.Printf("gcd(s^e - m, n) from a glitched signature (should be q):\r\n %x\r\n", factor)
+  _, err = priv.decrypt_crt(m, true)
+  fmt.Printf("Glitched signature with the check (should fail): %v\r\n", err)
+
+  println("\r\nTest PKCS#1 v1.5 against OpenSSL")
+  if fixture, err := read_fixture("testdata/rsa_pkcs1.txt"); err != nil {
+    fmt.Printf("Skipping: %s\r\n", err)
+  } else {
+    key := fixture_key(fixture)
+    msg := fixture["message"]
+    fmt.Printf("Fixture key: %v\r\n", key.Validate())
+    hashes := []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA512}
+    for i, name := range []string{"sha1", "sha256", "sha512"} {
+      hashed := hash_message(hashes[i], msg)
+      expected := fixture[name+"_signature"]
+      fmt.Printf("Verify OpenSSL's %s signature (should be <nil>): %v\r\n", name, VerifyPKCS1v15(&key.PublicKey, hashes[i], hashed, expected))
+      sig, _ := SignPKCS1v15(key, hashes[i], hashed)
+      fmt.Printf("Sign with %s, same as OpenSSL (should be true): %v\r\n", name, subtle.ConstantTimeCompare(sig, expected)==1)
+    }
+    decrypted, err := DecryptPKCS1v15(key, fixture["ciphertext"])
+    fmt.Printf("Decrypt OpenSSL's ciphertext: %q %v\r\n", decrypted, err)
+  }
+
+  println("\r\nTest PKCS#1 v1.5 against the standard library")
+  standard := priv.standard()
+  msg := []byte("attack at dawn")
+  ciphertext, _ := EncryptPKCS1v15(rand.Reader, &priv.PublicKey, msg)
+  decrypted, err := rsa.DecryptPKCS1v15(rand.Reader, standard, ciphertext)
+  fmt.Printf("Encrypt, then the standard library decrypts: %q %v\r\n", decrypted, err)
+  ciphertext, _ = rsa.EncryptPKCS1v15(rand.Reader, &standard.PublicKey, msg)
+  decrypted, err = DecryptPKCS1v15(priv, ciphertext)
+  fmt.Printf("The standard library encrypts, then decrypt: %q %v\r\n", decrypted, err)
+  hashed := hash_message(crypto.SHA256, msg)
+  sig, _ := SignPKCS1v15(priv, crypto.SHA256, hashed)
+  fmt.Printf("Sign, then the standard library verifies (should be <nil>): %v\r\n", rsa.VerifyPKCS1v15(&standard.PublicKey, crypto.SHA256, hashed, sig))
+  sig[len(sig)-1] ^= 1
+  fmt.Printf("Verify a tampered signature (should fail): %v\r\n", VerifyPKCS1v15(&priv.PublicKey, crypto.SHA256, hashed, sig))
+  ciphertext[len(ciphertext)-1] ^= 1
+  _, err = DecryptPKCS1v15(priv, ciphertext)
+  fmt.Printf("Decrypt a tampered ciphertext (should fail): %v\r\n", err)
+
+  println("\r\nTest OAEP against the PKCS #1 v2.1 test vectors")
+  if fixture, err := read_fixture("testdata/rsa_oaep.txt"); err != nil {
+    fmt.Printf("Skipping: %s\r\n", err)
+  } else {
+    key := fixture_key(fixture)
+    for i:=1;fixture[fmt.Sprintf("message_%d", i)]!=nil;i++ {
+      msg := fixture[fmt.Sprintf("message_%d", i)]
+      expected := fixture[fmt.Sprintf("ciphertext_%d", i)]
+      seed := bytes.NewReader(fixture[fmt.Sprintf("seed_%d", i)]) // Use the example's seed as the randomness
+      ciphertext, _ := EncryptOAEP(crypto.SHA1, seed, &key.PublicKey, msg, nil)
+      decrypted, err := DecryptOAEP(crypto.SHA1, key, expected, nil)
+      fmt.Printf("Example 1.%d: encrypts the same (should be true): %v, decrypts (should be true): %v %v\r\n", i,
+        subtle.ConstantTimeCompare(ciphertext, expected)==1, subtle.ConstantTimeCompare(decrypted, msg)==1, err)
+    }
+  }
+
+  println("\r\nTest OAEP against the standard library")
+  label := []byte("orders")
+  ciphertext, _ = EncryptOAEP(crypto.SHA256, rand.Reader, &priv.PublicKey, msg, label)
+  decrypted, err = rsa.DecryptOAEP(crypto.SHA256.New(), rand.Reader, standard, ciphertext, label)
+  fmt.Printf("Encrypt with SHA-256 and a label, then the standard library decrypts: %q %v\r\n", decrypted, err)
+  ciphertext, _ = rsa.EncryptOAEP(crypto.SHA1.New(), rand.Reader, &standard.PublicKey, msg, label)
+  decrypted, err = DecryptOAEP(crypto.SHA1, priv, ciphertext, label)
+  fmt.Printf("The standard library encrypts with SHA-1 and a label, then decrypt: %q %v\r\n", decrypted, err)
+  _, err = DecryptOAEP(crypto.SHA1, priv, ciphertext, []byte("invoices"))
+  fmt.Printf("Decrypt with the wrong label (should fail): %v\r\n", err)
+  ciphertext[0] ^= 1
+  _, err = DecryptOAEP(crypto.SHA1, priv, ciphertext, label)
+  fmt.Printf("Decrypt a tampered ciphertext (should fail): %v\r\n", err)
+
+  println("\r\nTest PSS against the standard library")
+  sig, _ = SignPSS(rand.Reader, priv, crypto.SHA256, hashed, PSSSaltLengthEqualsHash)
+  fmt.Printf("Sign with a 32 byte salt, then the standard library verifies (should be <nil>): %v\r\n",
+    rsa.VerifyPSS(&standard.PublicKey, crypto.SHA256, hashed, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}))
+  sig, _ = SignPSS(rand.Reader, priv, crypto.SHA512, hash_message(crypto.SHA512, msg), PSSSaltLengthAuto)
+  fmt.Printf("Sign with SHA-512 and the longest salt, then the standard library verifies (should be <nil>): %v\r\n",
+    rsa.VerifyPSS(&standard.PublicKey, crypto.SHA512, hash_message(crypto.SHA512, msg), sig, nil))
+  sig, _ = rsa.SignPSS(rand.Reader, standard, crypto.SHA256, hashed, &rsa.PSSOptions{SaltLength: 20})
+  fmt.Printf("The standard library signs with a 20 byte salt, then verify working out the salt length (should be <nil>): %v\r\n",
+    VerifyPSS(&priv.PublicKey, crypto.SHA256, hashed, sig, PSSSaltLengthAuto))
+  fmt.Printf("Verify with the salt length given (should be <nil>): %v\r\n", VerifyPSS(&priv.PublicKey, crypto.SHA256, hashed, sig, 20))
+  fmt.Printf("Verify with the wrong salt length (should fail): %v\r\n", VerifyPSS(&priv.PublicKey, crypto.SHA256, hashed, sig, 32))
+  fmt.Printf("Verify with the wrong hash (should fail): %v\r\n", VerifyPSS(&priv.PublicKey, crypto.SHA256, hash_message(crypto.SHA256, []byte("retreat")), sig, PSSSaltLengthAuto))
+
+  println("\r\nTest blinding")
+  wrong := 0
+  for i:=0;i<2*blinding_uses;i++ { // Enough to square the blinding values many times and make new ones
+    c, _ := create_random_bignum(rand.Reader, 1000)
+    blinded, err := priv.decrypt(c)
+    plain, _ := priv.decrypt_unblinded(c)
+    if err != nil || blinded.Cmp(plain)!=0 {
+      wrong++
+    }
+  }
+  fmt.Printf("Blinded decryptions different from unblinded ones, out of %d (should be 0): %d\r\n", 2*blinding_uses, wrong)
+
+  println("\r\nTest key validation")
+  small, _ := GenerateKeyWithExponent(rand.Reader, 1024, 3)
+  fmt.Printf("Key with e = 3: %v\r\n", small.Validate())
+  broken := *priv
+  broken.D = new(big.Int).Add(priv.D, big.NewInt(2))
+  fmt.Printf("Key with the wrong d (should fail): %v\r\n", broken.Validate())
+  broken = *priv
+  broken.Q = new(big.Int).Add(priv.P, big.NewInt(2))
+  fmt.Printf("Key with the wrong q (should fail): %v\r\n", broken.Validate())
+
+  println("\r\nTest key files against OpenSSL")
+  if fixture, err := read_fixture("testdata/rsa_pkcs1.txt"); err != nil {
+    fmt.Printf("%s\r\n", err)
+  } else {
+    // The same key in every format, from openssl rsa, pkcs8 and rsa -pubout/-RSAPublicKey_out
+    files := []string{"rsa_pkcs1.pem", "rsa_pkcs8.pem", "rsa_pkcs1_public.pem", "rsa_spki.pem"}
+    for _, file := range files {
+      data, err := os.ReadFile("testdata/" + file)
+      var key *PrivateKey
+      var pub *PublicKey
+      if err == nil {
+        key, pub, err = ParsePEM(data)
+      }
+      if err != nil {
+        fmt.Printf("%s: %s\r\n", file, err)
+        continue
+      }
+      var saved []byte
+      block, _ := pem.Decode(data)
+      switch block.Type {
+      case pem_pkcs1_private:
+        der, _ := MarshalPKCS1PrivateKey(key)
+        saved = encode_pem(block.Type, der)
+      case pem_pkcs8_private:
+        der, _ := MarshalPKCS8PrivateKey(key)
+        saved = encode_pem(block.Type, der)
+      case pem_pkcs1_public:
+        saved = encode_pem(block.Type, MarshalPKCS1PublicKey(pub))
+      case pem_pkix_public:
+        saved = encode_pem(block.Type, MarshalPKIXPublicKey(pub))
+      }
+      same := pub.N.Cmp(os2ip(fixture["n"]))==0 && (key == nil || key.D.Cmp(os2ip(fixture["d"]))==0)
+      fmt.Printf("%s: %s, read the right key (should be true): %v, saves the same (should be true): %v\r\n",
+        file, block.Type, same, bytes.Equal(saved, data))
+    }
+  }
+
+  println("\r\nTest key files against the standard library")
+  pkcs1, _ := MarshalPKCS1PrivateKey(priv)
+  pkcs8, _ := MarshalPKCS8PrivateKey(priv)
+  standard_pkcs8, _ := x509.MarshalPKCS8PrivateKey(standard)
+  standard_pkix, _ := x509.MarshalPKIXPublicKey(&standard.PublicKey)
+  fmt.Printf("PKCS#1 private key the same (should be true): %v\r\n", bytes.Equal(pkcs1, x509.MarshalPKCS1PrivateKey(standard)))
+  fmt.Printf("PKCS#1 public key the same (should be true): %v\r\n",
+    bytes.Equal(MarshalPKCS1PublicKey(&priv.PublicKey), x509.MarshalPKCS1PublicKey(&standard.PublicKey)))
+  fmt.Printf("PKCS#8 the same (should be true): %v\r\n", bytes.Equal(pkcs8, standard_pkcs8))
+  fmt.Printf("SubjectPublicKeyInfo the same (should be true): %v\r\n", bytes.Equal(MarshalPKIXPublicKey(&priv.PublicKey), standard_pkix))
+  read, err := ParsePKCS8PrivateKey(pkcs8)
+  fmt.Printf("Read our PKCS#8 back (should be true): %v %v\r\n", err == nil && read.D.Cmp(priv.D)==0 && read.Qinv.Cmp(priv.Qinv)==0, err)
+
+  println("\r\nTest OpenSSH and JSON Web Key files")
+  // From the same key as the OpenSSL files: ssh-keygen -p converted it to OpenSSH's format, ssh-keygen -y wrote
+  // the public key, and the JWK was worked out separately in Python
+  openssl, _ := os.ReadFile("testdata/rsa_pkcs1.pem")
+  expected, _, _ := ParsePEM(openssl)
+  if data, err := os.ReadFile("testdata/rsa_openssh"); err != nil {
+    fmt.Printf("%s\r\n", err)
+  } else if key, comment, err := ParseOpenSSHPrivateKey(data); err != nil {
+    fmt.Printf("rsa_openssh: %s\r\n", err)
+  } else {
+    // Save it with ssh-keygen's check number, which is the first 4 bytes of the private part, the last string
+    block, _ := pem.Decode(data)
+    r := &ssh_reader{data: block.Bytes[len(openssh_magic):]}
+    r.string() // Cipher
+    r.string() // KDF
+    r.string() // KDF options
+    r.uint32() // Number of keys
+    r.string() // Public key
+    saved, _ := MarshalOpenSSHPrivateKey(bytes.NewReader(r.string()), key, comment)
+    fmt.Printf("rsa_openssh: read the right key (should be true): %v, comment %q, saves the same (should be true): %v\r\n",
+      key.D.Cmp(expected.D)==0, comment, bytes.Equal(saved, data))
+  }
+  if data, err := os.ReadFile("testdata/rsa_openssh.pub"); err != nil {
+    fmt.Printf("%s\r\n", err)
+  } else if pub, comment, err := ParseAuthorizedKey(data); err != nil {
+    fmt.Printf("rsa_openssh.pub: %s\r\n", err)
+  } else {
+    fmt.Printf("rsa_openssh.pub: read the right key (should be true): %v, comment %q, saves the same (should be true): %v\r\n",
+      pub.N.Cmp(expected.N)==0, comment, bytes.Equal(MarshalAuthorizedKey(pub, comment), data))
+  }
+  if data, err := os.ReadFile("testdata/rsa_authorized_keys"); err != nil {
+    fmt.Printf("%s\r\n", err)
+  } else {
+    for i, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+      if strings.HasPrefix(line, "#") {
+        continue
+      }
+      pub, comment, err := ParseAuthorizedKey([]byte(line))
+      _, any_pub, any_err := ParseKey([]byte(line))
+      fmt.Printf("rsa_authorized_keys line %d: read the right key (should be true): %v, comment %q, ParseKey too (should be true): %v\r\n",
+        i+1, err == nil && pub.N.Cmp(expected.N)==0, comment, any_err == nil && any_pub.N.Cmp(expected.N)==0)
+    }
+  }
+  if data, err := os.ReadFile("testdata/rsa_jwk.json"); err != nil {
+    fmt.Printf("%s\r\n", err)
+  } else if key, _, err := ParseJWK(data); err != nil {
+    fmt.Printf("rsa_jwk.json: %s\r\n", err)
+  } else {
+    saved, _ := MarshalPrivateJWK(key)
+    fmt.Printf("rsa_jwk.json: read the right key (should be true): %v, saves the same (should be true): %v\r\n",
+      key.D.Cmp(expected.D)==0, bytes.Equal(append(saved, '\n'), data))
+  }
+  ssh_saved, _ := MarshalOpenSSHPrivateKey(rand.Reader, priv, "someone@somewhere")
+  ssh_read, comment, err := ParseOpenSSHPrivateKey(ssh_saved)
+  fmt.Printf("Read our OpenSSH key back (should be true): %v %q %v\r\n", err == nil && ssh_read.D.Cmp(priv.D)==0, comment, err)
+  jwk_saved, _ := MarshalPrivateJWK(priv)
+  jwk_read, _, err := ParseJWK(jwk_saved)
+  fmt.Printf("Read our JWK back (should be true): %v %v\r\n", err == nil && jwk_read.Qinv.Cmp(priv.Qinv)==0, err)
+  _, jwk_public, err := ParseJWK(MarshalPublicJWK(&priv.PublicKey))
+  fmt.Printf("Read our public JWK back (should be true): %v %v\r\n", err == nil && jwk_public.N.Cmp(priv.N)==0, err)
+  tampered := bytes.Replace(jwk_saved, []byte(`"e":"AQAB"`), []byte(`"e":"Aw"`), 1)
+  _, _, err = ParseJWK(tampered)
+  fmt.Printf("Read a JWK with the wrong e (should fail): %v\r\n", err)
+
+  println("\r\nTest encrypted private keys")
+  // PBKDF2 test vectors from RFC 6070 (SHA-1) and RFC 7914 section 11 (SHA-256)
+  hashes := []crypto.Hash{crypto.SHA1, crypto.SHA1, crypto.SHA1, crypto.SHA256, crypto.SHA256}
+  passwords := []string{"password", "password", "passwordPASSWORDpassword", "passwd", "Password"}
+  salts := []string{"salt", "salt", "saltSALTsaltSALTsaltSALTsaltSALTsalt", "salt", "NaCl"}
+  iterations := []int{1, 4096, 4096, 1, 80000}
+  keys := []string{
+    "0c60c80f961f0e71f3a9b524af6012062fe037a6",
+    "4b007901b765489abead49d926f721d065a429c1",
+    "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038",
+    "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+    "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+  }
+  for i, hash := range hashes {
+    key, _ := hex.DecodeString(keys[i])
+    fmt.Printf("PBKDF2 with %v, %q, %q, %d iterations (should be true): %v\r\n", hash, passwords[i], salts[i], iterations[i],
+      bytes.Equal(pbkdf2(hash, []byte(passwords[i]), []byte(salts[i]), iterations[i], len(key)), key))
+  }
+  // From the same key as the other OpenSSL files, by openssl pkcs8 -topk8 -v2 aes-256-cbc -v2prf hmacWithSHA256,
+  // and -v2 aes-128-cbc -v2prf hmacWithSHA1 -iter 1000
+  password := []byte("correct horse battery staple")
+  for _, file := range []string{"rsa_pkcs8_aes256.pem", "rsa_pkcs8_aes128.pem"} {
+    data, err := os.ReadFile("testdata/" + file)
+    if err != nil {
+      fmt.Printf("%s\r\n", err)
+      continue
+    }
+    key, err := ParseEncryptedPEM(data, password)
+    fmt.Printf("%s: read the right key (should be true): %v %v\r\n", file, err == nil && key.D.Cmp(expected.D)==0, err)
+    _, err = ParseEncryptedPEM(data, []byte("correct horse battery stapler"))
+    fmt.Printf("%s with the wrong password (should fail): %v\r\n", file, err)
+  }
+  encrypted, err := MarshalEncryptedPKCS8PrivateKey(rand.Reader, priv, password, 10000)
+  if err == nil {
+    read, err = ParseEncryptedPKCS8PrivateKey(encrypted, password)
+  }
+  fmt.Printf("Read our encrypted key back (should be true): %v %v\r\n", err == nil && read.D.Cmp(priv.D)==0, err)
+  wrong_passwords := 0
+  for i:=0;i<100;i++ {
+    if _, err := ParseEncryptedPKCS8PrivateKey(encrypted, []byte(fmt.Sprintf("guess %d", i))); err == nil {
+      wrong_passwords++
+    }
+  }
+  fmt.Printf("Wrong passwords accepted, out of 100 (should be 0): %d\r\n", wrong_passwords)
+  _, _, err = ParsePEM(encode_pem(pem_encrypted_private, encrypted))
+  fmt.Printf("Read it without a password (should fail): %v\r\n", err)
+  slow_kdf := der_sequence(oid_pbkdf2, der_sequence(der_element(der_octet_string_tag, make([]byte, 16)),
+    der_integer(big.NewInt(0x7fffffff))))
+  slow_cipher := der_sequence(oid_aes256_cbc, der_element(der_octet_string_tag, make([]byte, 16)))
+  _, err = ParseEncryptedPKCS8PrivateKey(der_sequence(der_sequence(oid_pbes2, der_sequence(slow_kdf, slow_cipher)),
+    der_element(der_octet_string_tag, make([]byte, 16))), password)
+  fmt.Printf("2^31-1 iterations (should fail): %v\r\n", err)
+
+  println("\r\nTest reading bad DER")
+  // Our PKCS#1 key starts 30 82 xx xx (SEQUENCE, 2 length bytes), then 02 01 00 (the version), then the rest
+  rest := pkcs1[7:]
+  broken_der, _ := MarshalPKCS1PrivateKey(&broken)
+  names := []string{"Cut short", "Something after it", "Length not as short as it could be",
+    "Integer with an extra zero byte", "Negative version", "Key that doesn't validate"}
+  bad := [][]byte{
+    pkcs1[:len(pkcs1)-1],
+    append(append([]byte{}, pkcs1...), 0),
+    append([]byte{0x30, 0x83, 0x00, pkcs1[2], pkcs1[3]}, pkcs1[4:]...),
+    der_element(der_sequence_tag, append([]byte{0x02, 0x02, 0x00, 0x00}, rest...)),
+    der_element(der_sequence_tag, append([]byte{0x02, 0x01, 0xff}, rest...)),
+    broken_der,
+  }
+  for i, name := range names {
+    _, err = ParsePKCS1PrivateKey(bad[i])
+    fmt.Printf("%s (should fail): %v\r\n", name, err)
+  }
 }
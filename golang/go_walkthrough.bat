@@ -0,0 +1,7 @@
+@echo off
+if exist go_walkthrough.8 del go_walkthrough.8
+if exist go_walkthrough.exe del go_walkthrough.exe
+8g go_walkthrough.go shared_aes.go shared_des.go shared_rsa.go shared_tr31.go shared_util.go
+if exist go_walkthrough.8 8l -o go_walkthrough.exe go_walkthrough.8
+if exist go_walkthrough.exe go_walkthrough.exe
+pause
\ No newline at end of file
@@ -0,0 +1,326 @@
+// Generates a document walking through AES, DES or RSA on your own inputs, showing and explaining every intermediate
+// value, as Markdown or a self-contained HTML page. The values all come from the ciphers' trace hooks, so they always
+// match what the code really does. The ciphers are in the shared files, so build this along with them:
+//  go run go_walkthrough.go shared_*.go
+// Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog - http://github.com/chrishulbert/crypto
+
+package main
+import "fmt"         // For printf
+import "os"          // For the command line
+import "errors"      // For the usage errors
+import "strconv"     // For the RSA bit numbers
+import "math/big"    // For RSA's big numbers
+import "crypto/rand" // For making RSA keys and messages
+import "html"        // For escaping the HTML
+import "strings"     // For building Markdown tables
+
+// Builds a walkthrough document, as either Markdown or a self-contained HTML page
+type document struct {
+  html bool   // HTML if true, otherwise Markdown
+  body string
+}
+
+// Adds a section heading
+func (d *document) heading(text string) {
+  if d.html {
+    d.body += "<h2>" + html.EscapeString(text) + "</h2>\n"
+  } else {
+    d.body += "## " + text + "\n\n"
+  }
+}
+
+// Adds a paragraph
+func (d *document) para(text string) {
+  if d.html {
+    d.body += "<p>" + html.EscapeString(text) + "</p>\n"
+  } else {
+    d.body += text + "\n\n"
+  }
+}
+
+// Adds a paragraph explaining a step, with the step's name in bold
+func (d *document) step(name string, text string) {
+  if d.html {
+    d.body += "<p><b>" + html.EscapeString(name) + "</b>: " + html.EscapeString(text) + "</p>\n"
+  } else {
+    d.body += "**" + name + "**: " + text + "\n\n"
+  }
+}
+
+// Adds preformatted text, eg a grid of bytes
+func (d *document) pre(text string) {
+  if d.html {
+    d.body += "<pre>" + html.EscapeString(text) + "</pre>\n"
+  } else {
+    d.body += "```\n" + text + "\n```\n\n"
+  }
+}
+
+// Adds a table
+func (d *document) table(headers []string, rows [][]string) {
+  if d.html {
+    d.body += "<table>\n<tr>"
+    for _,h := range headers {
+      d.body += "<th>" + html.EscapeString(h) + "</th>"
+    }
+    d.body += "</tr>\n"
+    for _,row := range rows {
+      d.body += "<tr>"
+      for _,cell := range row {
+        d.body += "<td>" + html.EscapeString(cell) + "</td>"
+      }
+      d.body += "</tr>\n"
+    }
+    d.body += "</table>\n"
+  } else {
+    d.body += "| " + strings.Join(headers," | ") + " |\n|"
+    for i:=0;i<len(headers);i++ {
+      d.body += "---|"
+    }
+    d.body += "\n"
+    for _,row := range rows {
+      d.body += "| " + strings.Join(row," | ") + " |\n"
+    }
+    d.body += "\n"
+  }
+}
+
+// Wraps the body up into the finished document
+func (d *document) render(title string) string {
+  if d.html {
+    return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>" + html.EscapeString(title) + "</title>\n" +
+      "<style>body{font-family:sans-serif;max-width:60em;margin:auto;padding:1em} pre,td{font-family:monospace} " +
+      "table{border-collapse:collapse} th,td{border:1px solid #ccc;padding:0.2em 0.6em;text-align:left}</style>\n" +
+      "</head>\n<body>\n<h1>" + html.EscapeString(title) + "</h1>\n" + d.body + "</body>\n</html>\n"
+  }
+  return "# " + title + "\n\n" + d.body
+}
+
+// Explanations of each step of the cipher, for the walkthrough
+var aes_step_names = map[string]string{
+  "input": "Input",
+  "start": "Start of round",
+  "s_box": "SubBytes",
+  "s_row": "ShiftRows",
+  "m_col": "MixColumns",
+  "k_sch": "AddRoundKey",
+  "output": "Output",
+}
+var aes_step_notes = map[string]string{
+  "input": "The 16 byte message is laid out as a 4x4 grid called the state, filling it a column at a time.",
+  "start": "The state going into this round, which is the result of the previous AddRoundKey.",
+  "s_box": "Every byte is replaced by its entry in the Rijndael S-box. This is the only non-linear step, and without it AES would just be a big system of linear equations that's easy to solve.",
+  "s_row": "Row 0 stays put, row 1 rotates left by 1, row 2 by 2 and row 3 by 3, so the bytes of each column get spread across all four columns.",
+  "m_col": "Each column is multiplied by a fixed matrix in the Galois field GF(2^8), so every byte in a column affects every other byte in it. Along with ShiftRows, this means after 2 rounds every output byte depends on every input byte.",
+  "k_sch": "This round's key, taken from the expanded key, gets xor'd into the state. It's the only step that uses the key.",
+  "output": "After the final AddRoundKey, the state is the ciphertext.",
+}
+
+// Generates a document walking through the encryption of a block, showing and explaining every intermediate state
+// This comes straight from encrypt_traced, so it always matches what the code really does
+func aes_walkthrough(m []byte, k []byte, as_html bool) string {
+  d := document{html: as_html}
+  d.para(fmt.Sprintf("This walks through encrypting the block %x with the 128 bit key %x, one step at a time.", m, k))
+
+  // Show the expanded key
+  d.heading("Key expansion")
+  d.para("The 16 byte key is expanded into 11 round keys of 16 bytes each, one for the initial AddRoundKey and one for each of the 10 rounds. Each 4 bytes of expanded key is the previous 4 bytes xor'd with the 4 bytes from 16 bytes before. Every 16 bytes, the previous 4 bytes are first rotated, put through the S-box, and xor'd with a round constant (rcon), so that each round key is different.")
+  keys := expand_key(k)
+  var rows [][]string
+  for round:=0; round<=10; round++ {
+    rows = append(rows, []string{fmt.Sprintf("%d", round), fmt.Sprintf("%x", keys[round*16:round*16+16])})
+  }
+  d.table([]string{"Round", "Round key"}, rows)
+
+  // Show each step of the cipher
+  c := encrypt_traced(m, k, func(round int, step string, state []byte) {
+    switch {
+    case step=="input":
+      d.heading("Initial round")
+    case step=="start" && round==10:
+      d.heading("Round 10 (the final round, which skips MixColumns)")
+    case step=="start":
+      d.heading(fmt.Sprintf("Round %d", round))
+    case step=="output":
+      d.heading("Result")
+    }
+    name := aes_step_names[step]
+    if step=="k_sch" {
+      name = fmt.Sprintf("AddRoundKey (round key %d)", round)
+    }
+    d.step(name, aes_step_notes[step])
+    d.pre(state_grid(state))
+  })
+  d.para(fmt.Sprintf("Ciphertext: %x", c))
+  return d.render("AES-128 walkthrough")
+}
+
+// Generates a document walking through a DES encryption, showing and explaining every intermediate value
+// This comes straight from des_encrypt_rounds, so it always matches what the code really does
+func des_walkthrough(m []byte, key []byte, as_html bool) string {
+  d := document{html: as_html}
+  d.para(fmt.Sprintf("This walks through encrypting the block %X with the 64 bit key %X, one step at a time.", m, key))
+
+  // The key schedule
+  d.heading("Key schedule")
+  d.step("K", "The key. Only 56 of its 64 bits get used: the last bit of each byte is a parity bit that DES ignores.")
+  d.pre(bits(key,8))
+  d.step("K+ = PC-1(K)", "The PC-1 permutation picks out the 56 key bits and shuffles them. The left 28 bits are called C0 and the right 28 are D0.")
+  d.pre(bits(pc1(key),7))
+  d.step("K1 to K16", "C and D are each rotated left by 1 or 2 bits for every round (1 bit in rounds 1, 2, 9 and 16), and the PC-2 permutation picks 48 of the 56 bits of CnDn to make the round's subkey Kn.")
+  subkeys := expand(key)
+  var rows [][]string
+  for i:=0;i<16;i++ {
+    rows = append(rows, []string{fmt.Sprintf("K%d", i+1), bits(subkeys[i],6)})
+  }
+  d.table([]string{"Subkey", "Bits"}, rows)
+
+  // The initial permutation
+  d.heading("Initial permutation")
+  d.step("M", "The message block.")
+  d.pre(bits(m,4))
+  d.step("IP", "The initial permutation shuffles the message bits. It adds nothing to the security, it was there to make the hardware of the 1970s simpler. The left 32 bits are L0 and the right 32 are R0.")
+  d.pre(bits(ip(m),4))
+
+  // The rounds
+  out := des_encrypt_rounds(m,subkeys,16,func(t des_trace) {
+    n := t.round
+    d.heading(fmt.Sprintf("Round %d", n))
+    d.table([]string{"Value", "Bits", "What happened"}, [][]string{
+      {fmt.Sprintf("E(R%d)", n-1), bits(t.e,6), "The E expansion copies some of the 32 bits of R to make 48, so it can be mixed with the 48 bit subkey."},
+      {fmt.Sprintf("K%d", n), bits(t.subkey,6), "This round's subkey."},
+      {fmt.Sprintf("K%d+E(R%d)", n, n-1), bits(t.sbox_in,6), "The expanded R xor'd with the subkey. This is the only place the key gets used. It's cut into 8 groups of 6 bits for the S-boxes."},
+      {"S1(B1)...S8(B8)", bits(t.sbox_out,4), "Each 6 bit group is looked up in its S-box, which gives 4 bits back. The S-boxes are the non-linear heart of DES."},
+      {fmt.Sprintf("f(R%d,K%d)", n-1, n), bits(t.f,4), "The P permutation shuffles the S-box outputs, so that next round each S-box's output feeds several different S-boxes."},
+      {fmt.Sprintf("L%d = R%d", n, n-1), bits(t.l,4), "The right half simply moves across to become the new left half."},
+      {fmt.Sprintf("R%d = L%d + f", n, n-1), bits(t.r,4), "The old left half is xor'd with f to make the new right half."},
+    })
+  })
+
+  // The final permutation
+  d.heading("Final permutation")
+  d.step("R16L16", "After the 16 rounds, the halves are swapped back over. This is what makes decryption the same as encryption with the subkeys in reverse order.")
+  d.pre(bits(ip(out),8)) // IP undoes IP-1, taking us back to R16L16
+  d.step("IP-1", "The inverse of the initial permutation gives the ciphertext.")
+  d.pre(bits(out,8))
+  d.para(fmt.Sprintf("Ciphertext: %X", out))
+  return d.render("DES walkthrough")
+}
+
+// Adds a table of every step of a square-and-multiply modular exponentiation to the document
+func exp_table(d *document, base *big.Int, exp *big.Int, mod *big.Int) *big.Int {
+  var rows [][]string
+  result := mod_exp_traced(base, exp, mod, func(bit int, set bool, result *big.Int) {
+    op := "square"
+    if set {
+      op = "square, multiply"
+    }
+    rows = append(rows, []string{strconv.Itoa(bit), strconv.Itoa(int(exp.Bit(bit))), op, fmt.Sprintf("%x", result)})
+  })
+  d.table([]string{"Bit", "Value", "Operation", "Result"}, rows)
+  return result
+}
+
+// Generates a document walking through making an RSA key of the given size, then encrypting and decrypting with it
+// If m is nil, a random message is used
+func rsa_walkthrough(bits int, m *big.Int, as_html bool) (string, error) {
+  d := document{html: as_html}
+  d.para(fmt.Sprintf("This walks through making a %d bit RSA key, then encrypting and decrypting a message with it. Small keys are fine for learning, but real keys need to be 2048 bits or more.", bits))
+
+  // Make the key, the same way as any other: GenerateKey makes sure p and q are different, and that d exists
+  priv, err := GenerateKey(rand.Reader, bits)
+  if err != nil {
+    return "", err
+  }
+  d.heading("Making the key")
+  d.step("p and q", "Two different random primes, each half the size of the key. They're made by trying random odd numbers, with the top two bits set so their product has the full number of bits, until one passes 20 rounds of the Rabin-Miller primality test.")
+  d.pre(fmt.Sprintf("p = %x\nq = %x", priv.P, priv.Q))
+  n := priv.N
+  d.step("n = p*q", "The modulus, which is the public key. Everything is done mod n. Working out p and q from n is the hard problem that keeps RSA secure.")
+  d.pre(fmt.Sprintf("n = %x", n))
+  e := big.NewInt(int64(priv.E))
+  d.step("e", "The public exponent. 0x10001 is the usual choice: it's prime, and only has two bits set, so encrypting is quick.")
+  d.pre(fmt.Sprintf("e = %x", e))
+  d.step("λ(n) = lcm(p-1, q-1)", "Carmichael's function: the smallest number such that m^λ(n) mod n = 1 for every m sharing no factors with n. Anyone who knows p and q can work this out, but nobody else can. The original RSA paper used phi = (p-1)*(q-1) instead, which λ(n) divides, so either works, but λ(n) gives a smaller d. If e shares a factor with λ(n) it has no inverse, so new primes get made.")
+  d.pre(fmt.Sprintf("λ(n) = %x", carmichael(priv.P, priv.Q)))
+  d.step("d = e^-1 mod λ(n)", "The private exponent, the modular multiplicative inverse of e: e*d mod λ(n) = 1. Since m^λ(n) mod n = 1, this means (m^e)^d mod n = m.")
+  d.pre(fmt.Sprintf("d = %x", priv.D))
+
+  // Encrypt
+  if m == nil {
+    m, _ = create_random_bignum(rand.Reader, bits/2)
+  }
+  d.heading("Encrypting: c = m^e mod n")
+  d.step("m", "The message, as a number less than n.")
+  d.pre(fmt.Sprintf("m = %x", m))
+  d.para("Raising to a huge power would make a huge number, so it's done with square-and-multiply, reducing mod n at every step. Going through the bits of e from the top, the result is squared, and if the bit is 1 it's also multiplied by m.")
+  c := exp_table(&d, m, e, n)
+  d.step("c", "The ciphertext.")
+  d.pre(fmt.Sprintf("c = %x", c))
+
+  // Decrypt
+  d.heading("Decrypting: m = c^d mod n")
+  d.para("Exactly the same square-and-multiply, but with the private exponent d, which is much bigger than e, so it takes a lot more steps. The time it takes depends on the bits of d, which is why real implementations have to be careful about timing attacks.")
+  a := exp_table(&d, c, priv.D, n)
+  if a.Cmp(m)!=0 {
+    return "", errors.New("rsa: decrypting didn't give back the message")
+  }
+  d.step("m", "The decrypted message, which matches the original.")
+  d.pre(fmt.Sprintf("m = %x", a))
+  return d.render("RSA walkthrough"), nil
+}
+
+// Writes the document to stdout:
+//  go_walkthrough aes md|html key msg - Encrypting a 16 byte block with a 16 byte key, all in hex
+//  go_walkthrough des md|html key msg - Encrypting an 8 byte block with an 8 byte key, all in hex
+//  go_walkthrough rsa md|html [bits [msg]] - Making a key (default 64 bits), and encrypting and decrypting a message
+//    (default random, otherwise hex) with it
+//  eg: go_walkthrough aes html 2b7e151628aed2a6abf7158809cf4f3c 3243f6a8885a308d313198a2e0370734 > aes.html
+func main() {
+  var err error
+  as_html := len(os.Args)>=3 && os.Args[2]=="html"
+  switch {
+  case len(os.Args)<3 || (os.Args[2]!="md" && os.Args[2]!="html"):
+    err = errors.New("unknown command, see the comment above main() for usage")
+  case len(os.Args)==5 && os.Args[1]=="aes":
+    var k,m []byte
+    if k,err = parse_hex(os.Args[3],16); err == nil {
+      if m,err = parse_hex(os.Args[4],16); err == nil {
+        fmt.Print(aes_walkthrough(m,k,as_html))
+      }
+    }
+  case len(os.Args)==5 && os.Args[1]=="des":
+    var k,m []byte
+    if k,err = parse_hex(os.Args[3],8); err == nil {
+      if m,err = parse_hex(os.Args[4],8); err == nil {
+        fmt.Print(des_walkthrough(m,k,as_html))
+      }
+    }
+  case len(os.Args)<=5 && os.Args[1]=="rsa":
+    bits := 64
+    var m *big.Int
+    if len(os.Args)>=4 {
+      if bits,err = strconv.Atoi(os.Args[3]); err == nil && (bits<16 || bits>MaxKeyBits) {
+        err = fmt.Errorf("rsa: keys must be from 16 to %d bits", MaxKeyBits)
+      }
+    }
+    if err == nil && len(os.Args)==5 {
+      var ok bool
+      if m,ok = new(big.Int).SetString(os.Args[4],16); !ok || m.BitLen()>=bits-1 { // The message must be less than n
+        err = errors.New("rsa: the message must be hex, and less than n")
+      }
+    }
+    var doc string
+    if err == nil {
+      if doc,err = rsa_walkthrough(bits,m,as_html); err == nil {
+        fmt.Print(doc)
+      }
+    }
+  default:
+    err = errors.New("unknown command, see the comment above main() for usage")
+  }
+  if err != nil {
+    fmt.Printf("%s\r\n", err)
+    os.Exit(1)
+  }
+}
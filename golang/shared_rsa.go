@@ -0,0 +1,309 @@
+// RSA keys: generating and validating them, and the raw operations with CRT and blinding
+// Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog
+// http://github.com/chrishulbert/crypto
+// References:
+//  http://www.di-mgt.com.au/rsa_alg.html
+//  http://people.csail.mit.edu/rivest/Rsapaper.pdf
+
+package main
+import "fmt"         // For the key size error
+import "math/big"    // For the big numbers required for RSA
+import "crypto/rand" // For the blinding values
+import "errors"      // For the key validation errors
+import "io"          // For the source of randomness
+import "sync"        // For sharing the blinding values between goroutines
+
+// Make a random bignum of size bits, with the highest two and low bit set, from the given source of randomness
+// (normally crypto/rand's Reader)
+func create_random_bignum(random io.Reader, bits int) (num *big.Int, err error) {
+  buf := make([]byte, (bits+7)/8) // Enough random bytes for the bits
+  if _, err = io.ReadFull(random, buf); err != nil {
+    return nil, err
+  }
+  num = new(big.Int).SetBytes(buf)
+  num.Rsh(num, uint(len(buf)*8-bits)) // Drop the extra bits from the last byte
+  num.SetBit(num, bits-1, 1) // Set the highest 2 bits, so multiplying two of them gives the full number of bits
+  num.SetBit(num, bits-2, 1)
+  num.SetBit(num, 0, 1) // Set the lowest bit, so it's odd
+  return
+}
+
+// Create random numbers until it finds a prime
+func create_random_prime(random io.Reader, bits int) (prime *big.Int, err error) {
+  for {
+    if prime, err = create_random_bignum(random, bits); err != nil { // Create a random number
+      return nil, err
+    }
+    if prime.ProbablyPrime(20) { // Do 20 rabin-miller tests to check if it's prime
+      return
+    }
+  }
+}
+
+// The usual public exponent: it's prime, and only has two bits set, so encrypting is quick
+const DefaultExponent = 0x10001
+
+// The biggest key we'll make. Finding primes gets slower with the cube of their size, so without a limit anyone who
+// can choose the size could keep us busy, or run us out of memory, for as long as they liked
+const MaxKeyBits = 16384
+
+// An RSA public key
+type PublicKey struct {
+  N *big.Int // The modulus
+  E int      // The public exponent
+}
+
+// An RSA private key, which includes the public key
+type PrivateKey struct {
+  PublicKey
+  D *big.Int // The private exponent
+  P *big.Int // The prime factors of N
+  Q *big.Int
+
+  // For decrypting by the Chinese remainder theorem, made by Precompute. Nil if not made yet
+  Dp   *big.Int // d mod (p-1)
+  Dq   *big.Int // d mod (q-1)
+  Qinv *big.Int // q^-1 mod p
+
+  blinding *blinding // Made on the first decryption, see decrypt
+}
+
+// Makes a private key with a modulus of the given number of bits and the usual public exponent
+func GenerateKey(random io.Reader, bits int) (*PrivateKey, error) {
+  return GenerateKeyWithExponent(random, bits, DefaultExponent)
+}
+
+// Makes a private key with a modulus of the given number of bits and the given public exponent, which must be odd
+// and at least 3. Smaller exponents like 3 make encrypting quicker, but need proper padding to be safe
+func GenerateKeyWithExponent(random io.Reader, bits int, e int) (*PrivateKey, error) {
+  if bits<16 || bits>MaxKeyBits {
+    return nil, fmt.Errorf("rsa: keys must be from 16 to %d bits, got %d", MaxKeyBits, bits)
+  }
+  if e<3 || e%2==0 {
+    return nil, errors.New("rsa: the public exponent must be odd and at least 3")
+  }
+  one := big.NewInt(1)
+  big_e := big.NewInt(int64(e))
+  for {
+    // Generate P and Q, two big prime numbers, each half the size of the key
+    p, err := create_random_prime(random, bits/2)
+    if err != nil {
+      return nil, err
+    }
+    q, err := create_random_prime(random, bits-bits/2)
+    if err != nil {
+      return nil, err
+    }
+
+    // e has to have an inverse mod lambda(n), so it can't share a factor with p-1 or q-1. If it does, try new primes
+    lambda := carmichael(p, q)
+    if p.Cmp(q)==0 || new(big.Int).GCD(nil, nil, big_e, lambda).Cmp(one)!=0 {
+      continue
+    }
+    priv := &PrivateKey{PublicKey: PublicKey{N: new(big.Int).Mul(p, q), E: e}, P: p, Q: q}
+    priv.D = new(big.Int).ModInverse(big_e, lambda) // The private exponent: e*d mod lambda(n) = 1
+    priv.Precompute()
+    return priv, nil
+  }
+}
+
+// Carmichael's function lambda(n) = lcm(p-1, q-1): the smallest number such that m^lambda(n) mod n = 1 for every m
+// sharing no factors with n. It divides phi = (p-1)*(q-1), so either works for making d, but lambda gives a smaller d
+func carmichael(p *big.Int, q *big.Int) *big.Int {
+  one := big.NewInt(1)
+  p_minus_1 := new(big.Int).Sub(p, one)
+  q_minus_1 := new(big.Int).Sub(q, one)
+  gcd := new(big.Int).GCD(nil, nil, p_minus_1, q_minus_1)
+  lambda := new(big.Int).Mul(p_minus_1, q_minus_1)
+  return lambda.Div(lambda, gcd)
+}
+
+// Checks the key is consistent and sensibly made:
+//  p and q are different primes, and p*q = n
+//  e is odd and at least 3, and e*d mod lambda(n) = 1, so decrypting undoes encrypting
+//  p and q are about the same size, and not too close together (else n can be factored by Fermat's method)
+func (priv *PrivateKey) Validate() error {
+  if priv.N==nil || priv.D==nil || priv.P==nil || priv.Q==nil {
+    return errors.New("rsa: key is missing values")
+  }
+  if priv.E<3 || priv.E%2==0 {
+    return errors.New("rsa: the public exponent must be odd and at least 3")
+  }
+  if !priv.P.ProbablyPrime(20) || !priv.Q.ProbablyPrime(20) {
+    return errors.New("rsa: p or q isn't prime")
+  }
+  if priv.P.Cmp(priv.Q)==0 {
+    return errors.New("rsa: p and q are the same")
+  }
+  if new(big.Int).Mul(priv.P, priv.Q).Cmp(priv.N)!=0 {
+    return errors.New("rsa: p*q isn't n")
+  }
+  ed := new(big.Int).Mul(big.NewInt(int64(priv.E)), priv.D)
+  if ed.Mod(ed, carmichael(priv.P, priv.Q)).Cmp(big.NewInt(1))!=0 {
+    return errors.New("rsa: e*d mod lambda(n) isn't 1")
+  }
+  if diff := priv.P.BitLen()-priv.Q.BitLen(); diff>1 || diff< -1 {
+    return errors.New("rsa: p and q aren't the same size")
+  }
+  // FIPS 186-4 B.3.1: |p-q| must be more than 2^(bits/2-100), for keys big enough for that to mean anything
+  half := priv.N.BitLen()/2
+  if distance := new(big.Int).Sub(priv.P, priv.Q); half>100 && distance.Abs(distance).BitLen()<=half-100 {
+    return errors.New("rsa: p and q are too close together")
+  }
+  if priv.Dp != nil {
+    dp, dq, qinv := priv.crt_values()
+    if qinv==nil || priv.Dq==nil || priv.Qinv==nil || dp.Cmp(priv.Dp)!=0 || dq.Cmp(priv.Dq)!=0 || qinv.Cmp(priv.Qinv)!=0 {
+      return errors.New("rsa: the CRT values don't match the key")
+    }
+  }
+  return nil
+}
+
+// Works out the values for decrypting by the Chinese remainder theorem (see crt), and keeps them in the key
+// If q has no inverse mod p (eg p = q) they're all left nil, so decrypting falls back to c^d mod n
+func (priv *PrivateKey) Precompute() {
+  priv.Dp, priv.Dq, priv.Qinv = nil, nil, nil
+  if dp, dq, qinv := priv.crt_values(); qinv != nil {
+    priv.Dp, priv.Dq, priv.Qinv = dp, dq, qinv
+  }
+}
+
+// Works out d mod (p-1), d mod (q-1) and q^-1 mod p without changing the key. p and q must be more than 1, and
+// qinv is nil if q has no inverse mod p, eg if p = q
+func (priv *PrivateKey) crt_values() (dp *big.Int, dq *big.Int, qinv *big.Int) {
+  one := big.NewInt(1)
+  dp = new(big.Int).Mod(priv.D, new(big.Int).Sub(priv.P, one))
+  dq = new(big.Int).Mod(priv.D, new(big.Int).Sub(priv.Q, one))
+  qinv = new(big.Int).ModInverse(priv.Q, priv.P)
+  return
+}
+
+// The raw RSA operations, with no padding: c = m^e mod n, and m = c^d mod n. Signing is the same sum as decrypting
+func (pub *PublicKey) encrypt(m *big.Int) *big.Int {
+  return new(big.Int).Exp(m, big.NewInt(int64(pub.E)), pub.N)
+}
+func (priv *PrivateKey) decrypt_plain(c *big.Int) *big.Int {
+  return new(big.Int).Exp(c, priv.D, priv.N)
+}
+
+// Decrypts by the Chinese remainder theorem: rather than one exponentiation mod n, do two mod p and mod q, which
+// are half the size with exponents half the size, so about 4 times quicker in total. Then put the halves back
+// together with Garner's formula:
+//  m1 = c^dP mod p
+//  m2 = c^dQ mod q
+//  h = qInv * (m1 - m2) mod p
+//  m = m2 + h*q
+// 'glitch' simulates a fault in the mod p half, eg from a voltage glitch, to show the Bellcore attack (see go_rsa.go's
+// main)
+func (priv *PrivateKey) crt(c *big.Int, glitch bool) *big.Int {
+  m1 := new(big.Int).Exp(c, priv.Dp, priv.P)
+  m2 := new(big.Int).Exp(c, priv.Dq, priv.Q)
+  if glitch {
+    m1.SetBit(m1, 0, m1.Bit(0)^1)
+  }
+  h := new(big.Int).Sub(m1, m2)
+  h.Mul(h, priv.Qinv)
+  h.Mod(h, priv.P)
+  return h.Mul(h, priv.Q).Add(h, m2)
+}
+
+// Decrypts (or signs) with blinding. How long c^d mod n takes depends on c, eg by CRT a c less than p skips the
+// reduction mod p, so timing chosen ciphertexts gives away the key bit by bit (Kocher 1996, Brumley and Boneh 2003,
+// over a network). Blinding decrypts c*r^e instead, for a random r, which has nothing to do with c, then multiplies
+// the result by r^-1, since (c*r^e)^d = c^d*r. See blinding_experiment in go_rsa.go
+func (priv *PrivateKey) decrypt(c *big.Int) (*big.Int, error) {
+  return priv.blinded(c, priv.decrypt_unblinded)
+}
+
+// Blinds c, decrypts it with the given function, and unblinds the result
+func (priv *PrivateKey) blinded(c *big.Int, decrypt func(c *big.Int) (*big.Int, error)) (*big.Int, error) {
+  blind, unblind, err := priv.blinding_values()
+  if err != nil {
+    return nil, err
+  }
+  m, err := decrypt(new(big.Int).Mod(new(big.Int).Mul(c, blind), priv.N))
+  if err != nil {
+    return nil, err
+  }
+  return m.Mul(m, unblind).Mod(m, priv.N), nil
+}
+
+// A key's blinding values: r^e and r^-1 mod n. Making them needs a random number, an inverse and an exponentiation,
+// so rather than new ones every time, both are squared after each use: (r^e)^2 = (r^2)^e and (r^-1)^2 = (r^2)^-1,
+// so they still match, and the next r is r^2. Squaring is cheap but makes each r follow from the last, so r is made
+// afresh every blinding_uses decryptions, the same as OpenSSL
+type blinding struct {
+  n *big.Int     // The modulus they were made for, in case the key's been changed
+  r_e *big.Int   // r^e mod n, multiplied into the ciphertext
+  r_inv *big.Int // r^-1 mod n, multiplied into the result
+  uses int       // Decryptions since r was made
+}
+
+const blinding_uses = 32
+
+var blinding_lock sync.Mutex // Guards every key's blinding values, so a key can decrypt on several goroutines
+
+// Gets the blinding values for the next decryption, and moves the cache on
+func (priv *PrivateKey) blinding_values() (blind *big.Int, unblind *big.Int, err error) {
+  blinding_lock.Lock()
+  defer blinding_lock.Unlock()
+  b := priv.blinding
+  if b == nil || b.n.Cmp(priv.N)!=0 || b.uses>=blinding_uses {
+    b = &blinding{n: priv.N}
+    for b.r_inv == nil { // r must be coprime to n, which a random r almost certainly is
+      r, err := rand.Int(rand.Reader, priv.N)
+      if err != nil {
+        return nil, nil, err
+      }
+      b.r_e = priv.encrypt(r)
+      b.r_inv = new(big.Int).ModInverse(r, priv.N)
+    }
+    priv.blinding = b
+  }
+  blind, unblind = new(big.Int).Set(b.r_e), new(big.Int).Set(b.r_inv)
+  b.r_e.Mul(b.r_e, b.r_e).Mod(b.r_e, priv.N)
+  b.r_inv.Mul(b.r_inv, b.r_inv).Mod(b.r_inv, priv.N)
+  b.uses++
+  return blind, unblind, nil
+}
+
+// Decrypts (or signs), by the Chinese remainder theorem if the CRT values have been made, checking the result.
+// A fault in one half of a CRT decryption gives an answer that's right mod one prime but wrong mod the other, so the
+// gcd of n and the difference from the right answer is that prime: one faulty signature gives away the key (the
+// Bellcore attack, Boneh, DeMillo and Lipton 1997). So it checks the answer by encrypting it again, and never lets
+// out a wrong one
+func (priv *PrivateKey) decrypt_unblinded(c *big.Int) (*big.Int, error) {
+  if priv.Dp == nil || priv.Dq == nil || priv.Qinv == nil {
+    return priv.decrypt_plain(c), nil
+  }
+  return priv.decrypt_crt(c, false)
+}
+
+// Decrypts by the Chinese remainder theorem and checks the result, optionally with a simulated glitch
+func (priv *PrivateKey) decrypt_crt(c *big.Int, glitch bool) (*big.Int, error) {
+  m := priv.crt(c, glitch)
+  if priv.encrypt(m).Cmp(new(big.Int).Mod(c, priv.N))!=0 {
+    return nil, errors.New("rsa: fault detected while decrypting")
+  }
+  return m, nil
+}
+
+// Modular exponentiation by square-and-multiply: the same sum as big.Int's Exp, but slowly enough to watch
+// Go through the exponent's bits from the top: square the result each time, and if the bit is set, multiply by the base
+// The tracer gets told the result after each bit
+func mod_exp_traced(base *big.Int, exp *big.Int, mod *big.Int, tracer func(bit int, set bool, result *big.Int)) *big.Int {
+  result := big.NewInt(1)
+  for i:=exp.BitLen()-1; i>=0; i-- {
+    result.Mul(result, result) // result = result^2 mod n
+    result.Mod(result, mod)
+    set := exp.Bit(i)==1
+    if set {
+      result.Mul(result, base) // result = result*base mod n
+      result.Mod(result, mod)
+    }
+    if tracer != nil {
+      tracer(i, set, new(big.Int).Set(result))
+    }
+  }
+  return result
+}
@@ -0,0 +1,215 @@
+// TR-31 key block headers, and wrapping and unwrapping for any version
+// Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog - http://github.com/chrishulbert/crypto
+// Reference: ANSI X9 TR-31:2018
+
+package main
+import "fmt"           // For formatting the header and key block
+import "errors"        // For the TR-31 validation errors
+import "crypto/rand"   // For the random padding in TR-31 key blocks
+import "crypto/subtle" // For comparing MACs in constant time
+import "encoding/hex"  // For decoding the hex in TR-31 key blocks
+
+// TR-31 key blocks are how keys get exchanged with HSMs: the key is encrypted under a key block protection key (KBPK),
+// and a 16 character header describing what the key may be used for is MAC'd along with it so it can't be tampered with
+type tr31_header struct {
+  version       byte   // 'B' for a triple DES KBPK, 'D' for an AES KBPK
+  key_usage     string // 2 characters, eg "D0" data encryption, "K0" key encryption, "P0" PIN encryption, "M3" MAC
+  algorithm     byte   // Algorithm of the wrapped key, eg 'T' triple DES, 'A' AES, 'D' DES, 'H' HMAC
+  mode_of_use   byte   // eg 'B' encrypt and decrypt, 'E' encrypt only, 'D' decrypt only, 'C' MAC, 'N' no restrictions
+  key_version   string // 2 characters, "00" if key versioning isn't used
+  exportability byte   // 'E' exportable, 'N' non-exportable, 'S' sensitive
+}
+
+// The values allowed in each header field
+var tr31_key_usages = []string{"B0","B1","B2","C0","D0","D1","D2","E0","E1","E2","E3","E4","E5","E6","I0","K0","K1","K2","K3","M0","M1","M2","M3","M4","M5","M6","M7","M8","P0","S0","S1","S2","V0","V1","V2","V3","V4"}
+var tr31_algorithms = "ADEHRST"
+var tr31_modes_of_use = "BCDEGNSTVXY"
+var tr31_exportabilities = "ENS"
+
+// Checks every field of a header has a value TR-31 allows
+func tr31_check_header(h tr31_header) error {
+  if h.version!='B' && h.version!='D' {
+    return errors.New("tr31: unsupported version ID " + string(h.version))
+  }
+  usage_ok := false
+  for _,u := range tr31_key_usages {
+    usage_ok = usage_ok || u==h.key_usage
+  }
+  if !usage_ok {
+    return errors.New("tr31: invalid key usage " + h.key_usage)
+  }
+  if !contains_byte(tr31_algorithms,h.algorithm) {
+    return errors.New("tr31: invalid algorithm " + string(h.algorithm))
+  }
+  if !contains_byte(tr31_modes_of_use,h.mode_of_use) {
+    return errors.New("tr31: invalid mode of use " + string(h.mode_of_use))
+  }
+  if len(h.key_version)!=2 || !is_alphanumeric(h.key_version) {
+    return errors.New("tr31: key version must be 2 alphanumeric characters")
+  }
+  if !contains_byte(tr31_exportabilities,h.exportability) {
+    return errors.New("tr31: invalid exportability " + string(h.exportability))
+  }
+  return nil
+}
+
+// Is the byte one of the characters in the string?
+func contains_byte(s string, b byte) bool {
+  for i:=0;i<len(s);i++ {
+    if s[i]==b {
+      return true
+    }
+  }
+  return false
+}
+
+// Is the string made of only 0-9 and A-Z?
+func is_alphanumeric(s string) bool {
+  for i:=0;i<len(s);i++ {
+    if !(s[i]>='0' && s[i]<='9') && !(s[i]>='A' && s[i]<='Z') {
+      return false
+    }
+  }
+  return true
+}
+
+// Encodes the header as its 16 characters, eg "B0080D0TB00E0000" or "D0112D0AB00E0000"
+// The length is of the whole key block: header + hex encrypted key data + hex MAC
+func tr31_encode_header(h tr31_header, length int) (string, error) {
+  if err := tr31_check_header(h); err != nil {
+    return "", err
+  }
+  if length<16 || length>9999 {
+    return "", errors.New("tr31: key block length must be 16 to 9999")
+  }
+  return fmt.Sprintf("%c%04d%s%c%c%s%c0000", h.version, length, h.key_usage, h.algorithm, h.mode_of_use, h.key_version, h.exportability), nil
+}
+
+// Parses and validates the 16 character header at the start of a key block, also returning the key block length it claims
+func tr31_parse_header(s string) (h tr31_header, length int, err error) {
+  if len(s)<16 {
+    err = errors.New("tr31: header must be 16 characters")
+    return
+  }
+  for i:=1;i<5;i++ { // Key block length, 4 decimal digits
+    if s[i]<'0' || s[i]>'9' {
+      err = errors.New("tr31: key block length must be 4 digits")
+      return
+    }
+    length = length*10 + int(s[i]-'0')
+  }
+  h = tr31_header{s[0], s[5:7], s[7], s[8], s[9:11], s[11]}
+  if s[12:14]!="00" {
+    err = errors.New("tr31: optional blocks are not supported")
+    return
+  }
+  if s[14:16]!="00" {
+    err = errors.New("tr31: reserved field must be 00")
+    return
+  }
+  err = tr31_check_header(h)
+  return
+}
+
+// What differs between the key block versions: the cipher protecting the key, and how its keys come from the KBPK
+type tr31_version struct {
+  id          byte                                                     // The version ID at the start of the header
+  block_size  int                                                      // The cipher's block size, which is also the MAC size
+  derive      func(kbpk []byte) (kbek []byte, kbmk []byte, err error)  // Checks the KBPK's size and derives the KBEK and KBMK
+  cmac        func(m []byte, key []byte) ([]byte, error)               // CMAC using the cipher
+  cbc_encrypt func(m []byte, key []byte, iv []byte) ([]byte, error)    // CBC mode encryption using the cipher
+  cbc_decrypt func(c []byte, key []byte, iv []byte) ([]byte, error)    // CBC mode decryption using the cipher
+}
+
+// Wraps a key into a TR-31 key block of the given version
+func tr31_wrap(v tr31_version, kbpk []byte, h tr31_header, key []byte) (string, error) {
+  if h.version!=v.id {
+    return "", errors.New("tr31: version " + string(v.id) + " key blocks need a '" + string(v.id) + "' header")
+  }
+  kbek,kbmk,err := v.derive(kbpk)
+  if err != nil {
+    return "", err
+  }
+  if len(key)==0 || len(key)>255 {
+    return "", errors.New("tr31: key must be 1 to 255 bytes")
+  }
+
+  // The key data is a 2 byte key length in bits, the key, then random padding up to a multiple of the block size
+  data := make([]byte,(2+len(key)+v.block_size-1)/v.block_size*v.block_size)
+  data[0] = byte(len(key)*8>>8)
+  data[1] = byte(len(key)*8)
+  copy(data[2:],key)
+  if _,err := rand.Read(data[2+len(key):]); err != nil {
+    return "", err
+  }
+
+  // The header includes the length of the whole key block, so work that out first
+  header, err := tr31_encode_header(h, 16+len(data)*2+v.block_size*2)
+  if err != nil {
+    return "", err
+  }
+
+  // MAC the header and the plaintext key data, then encrypt the key data using the MAC as the IV
+  mac, err := v.cmac(join([]byte(header),data),kbmk)
+  if err != nil {
+    return "", err
+  }
+  enc, err := v.cbc_encrypt(data,kbek,mac)
+  if err != nil {
+    return "", err
+  }
+  return header + fmt.Sprintf("%X%X",enc,mac), nil
+}
+
+// Unwraps a TR-31 key block of the given version, verifying the MAC before returning the header and key
+func tr31_unwrap(v tr31_version, kbpk []byte, block string) (h tr31_header, key []byte, err error) {
+  var length int
+  if h,length,err = tr31_parse_header(block); err != nil {
+    return
+  }
+  if h.version!=v.id {
+    err = errors.New("tr31: not a version " + string(v.id) + " key block")
+    return
+  }
+  if length!=len(block) {
+    err = errors.New("tr31: key block length doesn't match header")
+    return
+  }
+  kbek,kbmk,err := v.derive(kbpk)
+  if err != nil {
+    return
+  }
+  body,err := hex.DecodeString(block[16:])
+  if err != nil {
+    return
+  }
+  if len(body)<2*v.block_size || len(body)%v.block_size!=0 {
+    err = errors.New("tr31: key block body has the wrong length")
+    return
+  }
+
+  // The last block is the MAC, which was also the IV
+  enc := body[:len(body)-v.block_size]
+  mac := body[len(body)-v.block_size:]
+  data,err := v.cbc_decrypt(enc,kbek,mac)
+  if err != nil {
+    return
+  }
+  check,err := v.cmac(join([]byte(block[:16]),data),kbmk)
+  if err != nil {
+    return
+  }
+  if subtle.ConstantTimeCompare(check,mac)!=1 {
+    err = errors.New("tr31: MAC verification failed")
+    return
+  }
+
+  // Pull the key out from between the length and the padding
+  bits := int(data[0])<<8 + int(data[1])
+  if bits==0 || bits%8!=0 || 2+bits/8>len(data) {
+    err = errors.New("tr31: invalid key length in key data")
+    return
+  }
+  key = data[2:2+bits/8]
+  return
+}
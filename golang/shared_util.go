@@ -0,0 +1,116 @@
+// Little helpers for xor'ing, hex, randomness, key whitening and correlation
+// Chris Hulbert - chris.hulbert@gmail.com - http://splinter.com.au/blog - http://github.com/chrishulbert/crypto
+
+package main
+import "fmt"          // For printf
+import "encoding/hex" // For reading hex from the command line
+import "crypto/rand"  // For random keys and messages
+import "math"         // For square roots
+
+// Xor's 2 arrays
+func xor(a []byte, b []byte) (out []byte) {
+  out = make([]byte,len(a))
+  for i:=0;i<len(a);i++ {
+    out[i] = a[i] ^ b[i]
+  }
+  return
+}
+
+// Joins two arrays
+func join(a []byte, b []byte) (out []byte) {
+  out = make([]byte,len(a)+len(b))
+  copy(out,a)
+  copy(out[len(a):],b)
+  return
+}
+
+// Doubles a value in GF(2^n), which is how the CMAC subkeys are made:
+// shift everything left 1 bit, and if the top bit fell off, xor the last byte with rb
+// rb is 0x1B for 64 bit blocks (DES) or 0x87 for 128 bit blocks (AES)
+func cmac_double(in []byte, rb byte) (out []byte) {
+  out = make([]byte,len(in))
+  for i:=0;i<len(in)-1;i++ {
+    out[i] = (in[i]<<1) + (in[i+1]>>7)
+  }
+  out[len(in)-1] = in[len(in)-1]<<1
+  if in[0]&0x80 != 0 {
+    out[len(in)-1] ^= rb
+  }
+  return
+}
+
+// Convert a string eg 85E5A3D7356A61E29A8AFA559AD67102 into an array of bytes
+func to_bytes(s string) []byte {
+  l := len(s)/2
+  b := make([]byte,l)
+  for i:=0;i<l;i++ {
+    fmt.Sscanf(s[i*2:i*2+2],"%x", &b[i])
+  }
+  return b
+}
+
+// Like to_bytes, but for command line arguments: checks it's valid hex of the expected number of bytes
+func parse_hex(s string, length int) ([]byte, error) {
+  b,err := hex.DecodeString(s)
+  if err != nil || len(b)!=length {
+    return nil, fmt.Errorf("expected %d bytes of hex, got: %s", length, s)
+  }
+  return b, nil
+}
+
+// Pretty-print an array
+func pretty(label string, arr []byte) {
+  var s string=""
+  for i,b := range arr {
+    s += fmt.Sprintf("%02X",b)
+    if i<len(arr)-1 {
+      s += "-"
+    }
+  }
+  fmt.Printf("%s:\r\n%s\r\n", label, s)
+}
+
+// Makes a random array, for keys and plaintexts
+func random_bytes(n int) []byte {
+  b := make([]byte,n)
+  rand.Read(b)
+  return b
+}
+
+// A block cipher with its key already set, so it can be wrapped up or attacked: takes a block, returns the transformed block
+type block_cipher func(block []byte) []byte
+
+// Key whitening is a cheap way to make a block cipher's key longer: xor the message with a 'pre' key before
+// encrypting, and xor the result with a 'post' key after. Brute force now has to find the whitening keys too,
+// for the cost of 2 xor's per block. Works with any cipher here, eg des_encrypt, tripledes_encrypt, or AES through
+// aes_encrypter in shared_aes.go. The whitening keys are the size of the cipher's block: 8 bytes for DES, 16 for AES
+func whiten(encrypt block_cipher, pre []byte, post []byte) block_cipher {
+  return func(m []byte) []byte {
+    return xor(encrypt(xor(m,pre)),post)
+  }
+}
+
+// Reverses whiten: undo the post key, decrypt, then undo the pre key
+func unwhiten(decrypt block_cipher, pre []byte, post []byte) block_cipher {
+  return func(c []byte) []byte {
+    return xor(decrypt(xor(c,post)),pre)
+  }
+}
+
+// The Pearson correlation of a and b
+func correlation(a []float64, b []float64) float64 {
+  n := float64(len(a))
+  var sa,sb,saa,sbb,sab float64
+  for i := range a {
+    sa += a[i]
+    sb += b[i]
+    saa += a[i]*a[i]
+    sbb += b[i]*b[i]
+    sab += a[i]*b[i]
+  }
+  d := math.Sqrt((n*saa-sa*sa)*(n*sbb-sb*sb))
+  if d==0 {
+    return 0
+  }
+  return (n*sab-sa*sb)/d
+}